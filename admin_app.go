@@ -7,22 +7,33 @@ import (
 	"github.com/olekukonko/tablewriter"
 	"github.com/pki-io/controller"
 	"os"
+	"strings"
 )
 
 type AdminApp struct {
-	env *controller.Environment
+	env    *controller.Environment
+	output string
+	role   *Role
+	store  *OrgStore
 }
 
 func NewAdminApp() *AdminApp {
 	app := new(AdminApp)
 	app.env = controller.NewEnvironment()
+	app.output = *outputFormat
 	controller.UseLogger(logger)
 	return app
 }
 
 func (app *AdminApp) Exit() {
+	app.ExitWith(0)
+}
+
+// ExitWith exits with a status code for commands that report a non-fatal
+// failure, such as a check that found problems.
+func (app *AdminApp) ExitWith(code int) {
 	logger.Flush()
-	cli.Exit(0)
+	cli.Exit(code)
 }
 
 func (app *AdminApp) Fatal(err error) {
@@ -53,6 +64,43 @@ The pki.io team`
 	cli.Exit(1)
 }
 
+// Fail exits with an error that is the user's to fix, such as a stale invite,
+// rather than a bug.
+func (app *AdminApp) Fail(err error) {
+	logger.Critical(err)
+	app.ExitWith(1)
+}
+
+// Authorize exits unless the current admin's role allows perm. If the role is
+// scoped, tags is called to find the tags of what is being acted on, and at
+// least one must be in scope. A nil tags skips the scope check.
+func (app *AdminApp) Authorize(perm string, tags func() ([]string, error)) {
+	if app.role == nil {
+		role, err := loadRole(app, "")
+		if err != nil {
+			app.Fatal(err)
+		}
+		app.role = role
+	}
+
+	if !app.role.Allows(perm) {
+		app.Fail(fmt.Errorf("the %s role isn't allowed to %s", app.role.Name, perm))
+	}
+
+	if len(app.role.Scope) == 0 || tags == nil {
+		return
+	}
+
+	targetTags, err := tags()
+	if err != nil {
+		app.Fatal(err)
+	}
+
+	if !app.role.InScope(targetTags) {
+		app.Fail(fmt.Errorf("the %s role is limited to tags %s", app.role.Name, strings.Join(app.role.Scope, ", ")))
+	}
+}
+
 func (app *AdminApp) NewTable() *tablewriter.Table {
 	logger.Debug("creating table")
 	table := tablewriter.NewWriter(os.Stdout)
@@ -60,9 +108,3 @@ func (app *AdminApp) NewTable() *tablewriter.Table {
 
 	return table
 }
-
-func (app *AdminApp) RenderTable(table *tablewriter.Table) {
-	logger.Debug("rendering output")
-	logger.Flush()
-	table.Render()
-}
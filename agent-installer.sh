@@ -1,3 +1,118 @@
 #!/bin/sh
+#
+# Installs the pki.io agent on a node and registers it with the org.
+#
+#   agent-installer.sh --name NAME --pairing-id ID --agent FILE [OPTIONS]
+#
+# The pairing key is read from the first line of stdin and handed to the agent
+# in its environment, so that it never shows up in the process list. The agent
+# uses the org's shared --local directory and --home directory, as every other
+# org member does. Nodes swap tasks and certificates with the org through that
+# directory, so it has to be shared with this host already, over NFS or any
+# other shared filesystem, as copying it once wouldn't keep it in sync. Each step prints a line of the form
+#
+#   STEP <name> <ok|failed|skipped> [detail]
+#
+# and the script exits non-zero at the first failed step.
 
-echo "DOES NOTHING AT THE MOMENT"
+PREFIX="/usr/local"
+DATA_DIR="/var/lib/pki.io"
+INTERVAL="5m"
+SYSTEMD=1
+NAME=""
+PAIRING_ID=""
+AGENT=""
+LOCAL_DIR=""
+HOME_DIR=""
+UNIT_DIR="/etc/systemd/system"
+
+# Where a running systemd keeps its runtime state, only overridden by the tests
+SYSTEMD_RUN_DIR="${SYSTEMD_RUN_DIR:-/run/systemd/system}"
+
+usage() {
+  echo "usage: $0 --name NAME --pairing-id ID --agent FILE --local DIR [--home DIR] [--prefix DIR] [--data-dir DIR] [--interval INTERVAL] [--unit-dir DIR] [--no-systemd]" >&2
+  exit 2
+}
+
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --name) NAME="$2"; shift 2 ;;
+    --pairing-id) PAIRING_ID="$2"; shift 2 ;;
+    --agent) AGENT="$2"; shift 2 ;;
+    --local) LOCAL_DIR="$2"; shift 2 ;;
+    --home) HOME_DIR="$2"; shift 2 ;;
+    --prefix) PREFIX="$2"; shift 2 ;;
+    --data-dir) DATA_DIR="$2"; shift 2 ;;
+    --interval) INTERVAL="$2"; shift 2 ;;
+    --unit-dir) UNIT_DIR="$2"; shift 2 ;;
+    --no-systemd) SYSTEMD=0; shift ;;
+    *) usage ;;
+  esac
+done
+
+[ -n "$NAME" ] && [ -n "$PAIRING_ID" ] && [ -n "$AGENT" ] && [ -n "$LOCAL_DIR" ] || usage
+
+BIN="$PREFIX/bin/pki.io"
+UNIT="$UNIT_DIR/pki.io-agent.service"
+
+ok() {
+  echo "STEP $1 ok $2"
+}
+
+fail() {
+  echo "STEP $1 failed $2"
+  exit 1
+}
+
+skip() {
+  echo "STEP $1 skipped $2"
+}
+
+IFS= read -r PAIRING_KEY
+[ -n "$PAIRING_KEY" ] || fail pairing-key "no pairing key on stdin"
+ok pairing-key
+
+# Nothing is installed unless the org is there to register with
+[ -d "$LOCAL_DIR" ] || fail org-dir "$LOCAL_DIR isn't a directory, is the org shared with this host?"
+ok org-dir "$LOCAL_DIR"
+
+mkdir -p "$PREFIX/bin" && install -m 0755 "$AGENT" "$BIN" || fail install-agent "$BIN"
+ok install-agent "$BIN"
+
+mkdir -p "$DATA_DIR" && chmod 0700 "$DATA_DIR" || fail data-dir "$DATA_DIR"
+ok data-dir "$DATA_DIR"
+
+# Without --home the agent uses its default home directory
+out=$(cd "$LOCAL_DIR" && env ${HOME_DIR:+"PKIIO_HOME=$HOME_DIR"} PKIIO_LOCAL="$LOCAL_DIR" PKIIO_PAIRING_KEY="$PAIRING_KEY" \
+  "$BIN" node new "$NAME" --pairing-id "$PAIRING_ID" 2>&1) \
+  || fail register "$(echo "$out" | tail -n 1)"
+ok register "$NAME"
+
+if [ "$SYSTEMD" -eq 0 ]; then
+  skip systemd-unit "disabled"
+elif [ ! -d "$SYSTEMD_RUN_DIR" ]; then
+  skip systemd-unit "systemd not running"
+else
+  cat > "$UNIT" <<EOF || fail systemd-unit "$UNIT"
+[Unit]
+Description=pki.io agent for $NAME
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+${HOME_DIR:+Environment=PKIIO_HOME=$HOME_DIR}
+Environment=PKIIO_LOCAL=$LOCAL_DIR
+WorkingDirectory=$LOCAL_DIR
+ExecStart=$BIN node run $NAME --daemon --interval $INTERVAL --status-file $DATA_DIR/node-status.json
+ExecReload=/bin/kill -HUP \$MAINPID
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+EOF
+  ok systemd-unit "$UNIT"
+
+  { systemctl daemon-reload && systemctl enable --now pki.io-agent.service; } >/dev/null 2>&1 \
+    || fail start-agent "systemctl enable pki.io-agent.service"
+  ok start-agent "pki.io-agent.service"
+fi
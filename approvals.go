@@ -0,0 +1,285 @@
+// ThreatSpec package main
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Sensitive actions need the approval of a quorum of admins before they run,
+// with the admin requesting the action counting as the first approval. The
+// action string names exactly what was approved so that an approval can't
+// be reused for anything else.
+//
+// Approvals live in the org store and are only checked by this CLI. Every
+// admin holds the org keys, so they record the consent of other admins and
+// guard against mistakes, but can't stop an admin who uses another client.
+const (
+	ActionCADelete  string = "ca delete %s"
+	ActionCAPrivate string = "ca show --private %s"
+	ActionOrgDelete string = "org delete %s"
+	ActionOrgBackup string = "org backup %s"
+	ActionQuorumSet string = "admin quorum %d"
+)
+
+const (
+	ApprovalPending   string = "pending"
+	ApprovalApproved  string = "approved"
+	ApprovalDenied    string = "denied"
+	ApprovalCompleted string = "completed"
+)
+
+// approvalsDoc is the org store document holding the quorum and the approval
+// requests.
+const approvalsDoc = "approvals"
+
+// ExitApprovalPending is the exit status of a command that raised an
+// approval request instead of running.
+const ExitApprovalPending = 2
+
+// Approval is a request to run an action, with the names of the admins that
+// voted on it. Created is in unix seconds.
+type Approval struct {
+	Action    string   `json:"action"`
+	Requester string   `json:"requester"`
+	Created   int64    `json:"created"`
+	Approvals []string `json:"approvals"`
+	Denials   []string `json:"denials"`
+	Used      bool     `json:"used"`
+}
+
+// Approvals is the quorum and the approval requests by id. A quorum below
+// two means sensitive actions need no approval.
+type Approvals struct {
+	Quorum   int                  `json:"quorum"`
+	Requests map[string]*Approval `json:"requests"`
+}
+
+func newApprovals() *Approvals {
+	return &Approvals{Quorum: 1, Requests: make(map[string]*Approval)}
+}
+
+func (a *Approvals) Status(approval *Approval) string {
+	switch {
+	case approval.Used:
+		return ApprovalCompleted
+	case len(approval.Denials) > 0:
+		return ApprovalDenied
+	case len(approval.Approvals) >= a.Quorum:
+		return ApprovalApproved
+	default:
+		return ApprovalPending
+	}
+}
+
+// Ids returns the ids of the requests with status, or all requests if status
+// is empty, oldest first.
+func (a *Approvals) Ids(status string) []string {
+	var ids []string
+	for id, approval := range a.Requests {
+		if status == "" || a.Status(approval) == status {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return a.Requests[ids[i]].Created < a.Requests[ids[j]].Created })
+	return ids
+}
+
+func (a *Approvals) Output(id string) *OutputDoc {
+	approval := a.Requests[id]
+	return NewOutputDoc().
+		Add("id", "Id", id).
+		Add("action", "Action", approval.Action).
+		Add("requester", "Requester", approval.Requester).
+		Add("created", "Created", formatTime(approval.Created)).
+		Add("approvals", "Approvals", fmt.Sprintf("%d/%d", len(approval.Approvals), a.Quorum)).
+		Add("denials", "Denials", len(approval.Denials)).
+		Add("status", "Status", a.Status(approval))
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// loadApprovals reads the quorum and approval requests.
+func loadApprovals(app *AdminApp) (*Approvals, error) {
+	approvals := newApprovals()
+	if _, err := app.Store().Load(approvalsDoc, approvals); err != nil {
+		return nil, err
+	}
+	return approvals, nil
+}
+
+// updateApprovals changes the quorum and approval requests under the store's
+// lock.
+func updateApprovals(app *AdminApp, change func(*Approvals) error) (*Approvals, error) {
+	approvals := newApprovals()
+	err := app.Store().Update(approvalsDoc, approvals, func() error {
+		return change(approvals)
+	})
+	return approvals, err
+}
+
+// approver returns the name of the current admin, who has to be known to
+// request or vote on an approval.
+func approver(app *AdminApp) string {
+	name, err := currentAdmin(app)
+	if err != nil {
+		app.Fatal(err)
+	}
+	if name == "" {
+		app.Fail(fmt.Errorf("this home directory has no admin name for the org, so it can't take part in approvals"))
+	}
+	return name
+}
+
+func newApprovalId() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// approvalFor returns the request with approvalId, failing unless it is for
+// exactly action.
+func (a *Approvals) approvalFor(action, approvalId string) (*Approval, error) {
+	approval, ok := a.Requests[approvalId]
+	if !ok {
+		return nil, fmt.Errorf("approval request %s doesn't exist", approvalId)
+	}
+	if approval.Action != action {
+		return nil, fmt.Errorf("approval request %s is for '%s'", approvalId, approval.Action)
+	}
+	return approval, nil
+}
+
+// Approve returns once action may run. With a quorum of one it returns
+// straight away. Otherwise, without an approval id it raises a new approval
+// request and exits with ExitApprovalPending, and with one it fails unless a
+// quorum was reached for exactly this action. The approval is only used up by
+// CompleteApproval, so it should be called once everything else is checked,
+// right before the action.
+func (app *AdminApp) Approve(action, approvalId string) {
+	approvals, err := loadApprovals(app)
+	if err != nil {
+		app.Fatal(err)
+	}
+	if approvals.Quorum <= 1 {
+		return
+	}
+
+	name := approver(app)
+
+	if approvalId == "" {
+		logger.Infof("requesting approval for '%s'", action)
+		id, err := newApprovalId()
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		app.Audit("admin approval-request", action, id, "")
+
+		approvals, err = updateApprovals(app, func(approvals *Approvals) error {
+			approvals.Requests[id] = &Approval{
+				Action:    action,
+				Requester: name,
+				Created:   time.Now().Unix(),
+				Approvals: []string{name},
+			}
+			return nil
+		})
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		app.RenderItem(approvals.Output(id))
+		logger.Warnf("'%s' needs %d approvals, run it again with --approval %s once approved", action, approvals.Quorum, id)
+		app.ExitWith(ExitApprovalPending)
+	}
+
+	approval, err := approvals.approvalFor(action, approvalId)
+	if err != nil {
+		app.Fail(err)
+	}
+
+	switch approvals.Status(approval) {
+	case ApprovalPending:
+		app.Fail(fmt.Errorf("approval request %s hasn't reached quorum yet", approvalId))
+	case ApprovalDenied:
+		app.Fail(fmt.Errorf("approval request %s was denied", approvalId))
+	case ApprovalCompleted:
+		app.Fail(fmt.Errorf("approval request %s has already been used", approvalId))
+	}
+	logger.Infof("approval %s accepted for '%s'", approvalId, action)
+}
+
+// complete uses up the approval with approvalId for action. Approvals aren't
+// needed with a quorum of one, so then it does nothing.
+func (a *Approvals) complete(action, approvalId string) error {
+	if a.Quorum <= 1 || approvalId == "" {
+		return nil
+	}
+
+	approval, err := a.approvalFor(action, approvalId)
+	if err != nil {
+		return err
+	}
+	if approval.Used {
+		return fmt.Errorf("approval request %s has already been used", approvalId)
+	}
+	approval.Used = true
+	return nil
+}
+
+// CompleteApproval uses up the approval that Approve accepted for action,
+// once the action has succeeded.
+func (app *AdminApp) CompleteApproval(action, approvalId string) {
+	_, err := updateApprovals(app, func(approvals *Approvals) error {
+		return approvals.complete(action, approvalId)
+	})
+	if err != nil {
+		app.Fatal(err)
+	}
+}
+
+// Vote approves or denies a pending request as the current admin, who can't
+// be the requester.
+func (app *AdminApp) Vote(approvalId string, deny bool) *Approvals {
+	name := approver(app)
+
+	approvals, err := updateApprovals(app, func(approvals *Approvals) error {
+		approval, ok := approvals.Requests[approvalId]
+		if !ok {
+			return fmt.Errorf("approval request %s doesn't exist", approvalId)
+		}
+		if approvals.Status(approval) != ApprovalPending {
+			return fmt.Errorf("approval request %s is %s", approvalId, approvals.Status(approval))
+		}
+		if approval.Requester == name {
+			return fmt.Errorf("admins can't approve their own requests")
+		}
+		if contains(approval.Approvals, name) || contains(approval.Denials, name) {
+			return fmt.Errorf("admin '%s' has already voted on approval request %s", name, approvalId)
+		}
+
+		if deny {
+			approval.Denials = append(approval.Denials, name)
+		} else {
+			approval.Approvals = append(approval.Approvals, name)
+		}
+		return nil
+	})
+	if err != nil {
+		app.Fail(err)
+	}
+	return approvals
+}
@@ -0,0 +1,387 @@
+// ThreatSpec package main
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pki-io/controller"
+	"time"
+)
+
+// auditDoc is the org store document holding the audit log. Like the other
+// store documents it is encrypted and signed with the org's keys.
+const auditDoc = "audit"
+
+// auditKeysDoc is the org store document holding each admin's public audit
+// key. Every org member can write the store, so keys are also pinned in
+// auditSigningDoc the first time a home directory sees them.
+const auditKeysDoc = "audit_keys"
+
+// auditSigningDoc is the home directory document that records, for each org,
+// the private key this admin signs audit entries with and the public audit
+// keys seen of every admin.
+const auditSigningDoc = "audit_signing"
+
+// auditHeadsDoc is the home directory document that records, for each org,
+// the last audit entry this home directory saw, so that removing entries from
+// the end of the log can be detected.
+const auditHeadsDoc = "audit_heads"
+
+// AuditEntry is one entry in the org's audit log. Each entry holds the hash of
+// the one before it, so editing, removing or reordering entries breaks the
+// chain, and is signed with the audit key of the admin that made it, so that
+// one admin can't make entries under another's name.
+type AuditEntry struct {
+	Seq       int    `json:"seq"`
+	Time      int64  `json:"time"`
+	Admin     string `json:"admin"`
+	AdminId   string `json:"admin_id"`
+	Action    string `json:"action"`
+	Target    string `json:"target"`
+	TargetId  string `json:"target_id,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Prev      string `json:"prev"`
+	Hash      string `json:"hash"`
+	Signature string `json:"signature"`
+}
+
+// AuditKey is an admin's public audit key, base64 encoded.
+type AuditKey struct {
+	AdminId   string `json:"admin_id"`
+	PublicKey string `json:"public_key"`
+}
+
+// AuditKeys maps admin names to their audit keys.
+type AuditKeys map[string]*AuditKey
+
+// auditSigning is an admin's own audit key, base64 encoded, and the audit
+// keys of the org's admins seen by the admin.
+type auditSigning struct {
+	PrivateKey string    `json:"private_key"`
+	Seen       AuditKeys `json:"seen"`
+}
+
+// auditHead is the sequence number and hash of an audit entry.
+type auditHead struct {
+	Seq  int    `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// auditGenesis is the previous hash of the first entry.
+var auditGenesis = fmt.Sprintf("%064x", 0)
+
+// hash returns the hex SHA-256 of the entry without its hash and signature.
+func (e AuditEntry) hash() (string, error) {
+	e.Hash = ""
+	e.Signature = ""
+	content, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (e *AuditEntry) Output() *OutputDoc {
+	return NewOutputDoc().
+		Add("seq", "Seq", e.Seq).
+		Add("time", "Time", formatTime(e.Time)).
+		Add("admin", "Admin", e.Admin).
+		Add("admin_id", "Admin id", e.AdminId).
+		Add("action", "Action", e.Action).
+		Add("target", "Target", e.Target).
+		Add("target_id", "Target id", e.TargetId).
+		Add("reason", "Reason", e.Reason).
+		Add("prev", "Prev", e.Prev).
+		Add("hash", "Hash", e.Hash).
+		Add("signature", "Signature", e.Signature)
+}
+
+// loadAuditSigning returns this home directory's audit key and seen keys for
+// the org, with an empty key if it has none yet.
+func loadAuditSigning(app *AdminApp) (*auditSigning, error) {
+	signings := make(map[string]*auditSigning)
+	if err := loadHomeDoc(auditSigningDoc, &signings); err != nil {
+		return nil, err
+	}
+	signing, ok := signings[app.Store().org.Id()]
+	if !ok {
+		signing = new(auditSigning)
+	}
+	if signing.Seen == nil {
+		signing.Seen = make(AuditKeys)
+	}
+	return signing, nil
+}
+
+func saveAuditSigning(app *AdminApp, signing *auditSigning) error {
+	signings := make(map[string]*auditSigning)
+	if err := loadHomeDoc(auditSigningDoc, &signings); err != nil {
+		return err
+	}
+	signings[app.Store().org.Id()] = signing
+	return saveHomeDoc(auditSigningDoc, signings)
+}
+
+// auditSigner returns the current admin's audit key, making one and
+// registering it in the org the first time the admin audits anything. It
+// fails if the org holds another key for the admin.
+func auditSigner(app *AdminApp, admin, adminId string) (ed25519.PrivateKey, error) {
+	signing, err := loadAuditSigning(app)
+	if err != nil {
+		return nil, err
+	}
+
+	var key ed25519.PrivateKey
+	if signing.PrivateKey == "" {
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		signing.PrivateKey = base64.StdEncoding.EncodeToString(key.Seed())
+	} else {
+		seed, err := base64.StdEncoding.DecodeString(signing.PrivateKey)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid audit key in the home directory")
+		}
+		key = ed25519.NewKeyFromSeed(seed)
+	}
+
+	own := &AuditKey{AdminId: adminId, PublicKey: base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))}
+	keys := make(AuditKeys)
+	if _, err := app.Store().Load(auditKeysDoc, &keys); err != nil {
+		return nil, err
+	}
+	if current, ok := keys[admin]; ok && *current == *own {
+		return key, nil
+	}
+
+	err = app.Store().Update(auditKeysDoc, &keys, func() error {
+		if current, ok := keys[admin]; ok && *current != *own {
+			return fmt.Errorf("the org holds another audit key for admin '%s'", admin)
+		}
+		keys[admin] = own
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	signing.Seen[admin] = own
+	if err := saveAuditSigning(app, signing); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// adminId returns the id of the admin with name.
+func adminId(app *AdminApp, name string) (string, error) {
+	cont, err := controller.NewAdmin(app.env)
+	if err != nil {
+		return "", err
+	}
+
+	params := controller.NewAdminParams()
+	params.Name = &name
+	admin, err := cont.Show(params)
+	if err != nil {
+		return "", err
+	}
+	if admin == nil {
+		return "", fmt.Errorf("admin '%s' not found", name)
+	}
+	return admin.Id(), nil
+}
+
+// loadAuditLog reads every entry in the audit log, oldest first.
+func loadAuditLog(app *AdminApp) ([]*AuditEntry, error) {
+	entries := []*AuditEntry{}
+	if _, err := app.Store().Load(auditDoc, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// loadAuditHead returns the last entry this home directory saw of the org's
+// audit log, with a Seq of 0 if it has seen none.
+func loadAuditHead(app *AdminApp) (*auditHead, error) {
+	heads := make(map[string]*auditHead)
+	if err := loadHomeDoc(auditHeadsDoc, &heads); err != nil {
+		return nil, err
+	}
+	if head, ok := heads[app.Store().org.Id()]; ok {
+		return head, nil
+	}
+	return &auditHead{Hash: auditGenesis}, nil
+}
+
+// saveAuditHead records the last entry this home directory saw of the org's
+// audit log.
+func saveAuditHead(app *AdminApp, head *auditHead) error {
+	heads := make(map[string]*auditHead)
+	if err := loadHomeDoc(auditHeadsDoc, &heads); err != nil {
+		return err
+	}
+	heads[app.Store().org.Id()] = head
+	return saveHomeDoc(auditHeadsDoc, heads)
+}
+
+// Audit records a mutating command or private key export before it happens,
+// so that a command that fails part way still leaves a record. The target is
+// the name the command was given and targetId the entity's id where the
+// command has it. A command that can't be audited fails without making its
+// change.
+func (app *AdminApp) Audit(action, target, targetId, reason string) {
+	admin, err := currentAdmin(app)
+	if err != nil {
+		app.Fatal(err)
+	}
+	if admin == "" {
+		app.Fail(fmt.Errorf("this home directory has no admin name for the org, so it can't sign audit entries"))
+	}
+
+	id, err := adminId(app, admin)
+	if err != nil {
+		app.Fatal(err)
+	}
+
+	key, err := auditSigner(app, admin, id)
+	if err != nil {
+		app.Fatal(fmt.Errorf("could not load audit key: %s", err))
+	}
+
+	entry := &AuditEntry{
+		Time:     time.Now().Unix(),
+		Admin:    admin,
+		AdminId:  id,
+		Action:   action,
+		Target:   target,
+		TargetId: targetId,
+		Reason:   reason,
+	}
+
+	logger.Debugf("auditing '%s' on '%s'", action, target)
+	entries := []*AuditEntry{}
+	err = app.Store().Update(auditDoc, &entries, func() error {
+		entry.Seq = len(entries) + 1
+		entry.Prev = auditGenesis
+		if len(entries) > 0 {
+			entry.Prev = entries[len(entries)-1].Hash
+		}
+
+		hash, err := entry.hash()
+		if err != nil {
+			return err
+		}
+		entry.Hash = hash
+		entry.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(hash)))
+
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		app.Fatal(fmt.Errorf("could not write audit entry for '%s': %s", action, err))
+	}
+
+	if err := saveAuditHead(app, &auditHead{Seq: entry.Seq, Hash: entry.Hash}); err != nil {
+		app.Fatal(err)
+	}
+}
+
+// verifyAuditLog checks the sequence numbers and hash chain of the log, that
+// every entry is signed by the audit key of its admin, and that it still
+// holds head, and returns a description of every problem found.
+func verifyAuditLog(entries []*AuditEntry, keys AuditKeys, head *auditHead) []*OutputDoc {
+	problems := []*OutputDoc{}
+	problem := func(seq int, detail string) {
+		problems = append(problems, NewOutputDoc().Add("seq", "Seq", seq).Add("problem", "Problem", detail))
+	}
+
+	prev := auditGenesis
+	for i, entry := range entries {
+		if entry.Seq != i+1 {
+			problem(entry.Seq, fmt.Sprintf("expected sequence number %d", i+1))
+		}
+		if entry.Prev != prev {
+			problem(entry.Seq, "previous hash doesn't match the entry before it")
+		}
+		if hash, err := entry.hash(); err != nil {
+			problem(entry.Seq, fmt.Sprintf("could not hash the entry: %s", err))
+		} else if hash != entry.Hash {
+			problem(entry.Seq, "hash doesn't match the entry's content")
+		}
+		if detail := keys.check(entry); detail != "" {
+			problem(entry.Seq, detail)
+		}
+
+		prev = entry.Hash
+	}
+
+	if head.Seq > len(entries) {
+		problem(head.Seq, fmt.Sprintf("the log has %d entries but entry %d was seen before, entries have been removed", len(entries), head.Seq))
+	} else if head.Seq > 0 && entries[head.Seq-1].Hash != head.Hash {
+		problem(head.Seq, "entry doesn't match the one seen before, the log has been rewritten")
+	}
+
+	return problems
+}
+
+// check returns why the entry's signature isn't valid, or "" if it is.
+func (k AuditKeys) check(entry *AuditEntry) string {
+	key, ok := k[entry.Admin]
+	if !ok {
+		return fmt.Sprintf("admin '%s' has no audit key", entry.Admin)
+	}
+	if key.AdminId != entry.AdminId {
+		return fmt.Sprintf("admin id doesn't match the audit key of admin '%s'", entry.Admin)
+	}
+
+	public, err := base64.StdEncoding.DecodeString(key.PublicKey)
+	if err != nil || len(public) != ed25519.PublicKeySize {
+		return fmt.Sprintf("invalid audit key for admin '%s'", entry.Admin)
+	}
+	signature, err := base64.StdEncoding.DecodeString(entry.Signature)
+	if err != nil || !ed25519.Verify(public, []byte(entry.Hash), signature) {
+		return fmt.Sprintf("signature doesn't match the audit key of admin '%s'", entry.Admin)
+	}
+	return ""
+}
+
+// pinAuditKeys returns the org's audit keys, along with a problem for each
+// admin whose key differs from the one this home directory saw before, and
+// records the keys it hasn't seen yet. A replaced key is checked as the one
+// seen before, so entries signed with the new key fail too.
+func pinAuditKeys(app *AdminApp) (AuditKeys, []*OutputDoc, error) {
+	keys := make(AuditKeys)
+	if _, err := app.Store().Load(auditKeysDoc, &keys); err != nil {
+		return nil, nil, err
+	}
+
+	signing, err := loadAuditSigning(app)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	problems := []*OutputDoc{}
+	for name, key := range keys {
+		seen, ok := signing.Seen[name]
+		if !ok {
+			signing.Seen[name] = key
+			continue
+		}
+		if *seen != *key {
+			problems = append(problems, NewOutputDoc().Add("seq", "Seq", 0).Add("problem", "Problem", fmt.Sprintf("the audit key of admin '%s' has been replaced", name)))
+			keys[name] = seen
+		}
+	}
+
+	if err := saveAuditSigning(app, signing); err != nil {
+		return nil, nil, err
+	}
+	return keys, problems, nil
+}
@@ -0,0 +1,387 @@
+// ThreatSpec package main
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/crypto/pbkdf2"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A backup is a gzipped tar of the org's local and home directories, with a
+// manifest first, encrypted with AES-256-GCM under a key derived from a
+// passphrase. The header is authenticated along with the archive, so any
+// change to the file fails decryption.
+//
+//	magic | version (1) | iterations (4) | salt (16) | nonce (12) | ciphertext
+const (
+	backupMagic      string = "pki.io-backup"
+	backupVersion    byte   = 1
+	backupIterations uint32 = 200000
+	backupSaltSize   int    = 16
+	backupManifest   string = "manifest.json"
+	backupLocalDir   string = "local"
+	backupHomeDir    string = "home"
+)
+
+// BackupFile describes a file in a backup.
+type BackupFile struct {
+	Path   string `json:"path"`
+	Mode   int64  `json:"mode"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BackupManifest lists everything in a backup so that it can be checked
+// without restoring it.
+type BackupManifest struct {
+	Version int          `json:"version"`
+	Org     string       `json:"org"`
+	Created int64        `json:"created"`
+	Files   []BackupFile `json:"files"`
+}
+
+func (m *BackupManifest) Output() *OutputDoc {
+	var size int64
+	for _, file := range m.Files {
+		size += file.Size
+	}
+	return NewOutputDoc().
+		Add("org", "Org", m.Org).
+		Add("version", "Version", m.Version).
+		Add("created", "Created", formatTime(m.Created)).
+		Add("files", "Files", len(m.Files)).
+		Add("size", "Size", size)
+}
+
+func backupKey(passphrase string, salt []byte, iterations uint32) []byte {
+	return pbkdf2.Key([]byte(passphrase), salt, int(iterations), 32, sha256.New)
+}
+
+// collectBackup reads the regular files under dir into the archive under
+// prefix.
+func collectBackup(dir, prefix string, manifest *BackupManifest, contents map[string][]byte) error {
+	return filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			logger.Warnf("skipping '%s' in backup, it isn't a regular file", file)
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		name := path.Join(prefix, filepath.ToSlash(rel))
+		sum := sha256.Sum256(content)
+		manifest.Files = append(manifest.Files, BackupFile{
+			Path:   name,
+			Mode:   int64(info.Mode().Perm()),
+			Size:   int64(len(content)),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+		contents[name] = content
+		return nil
+	})
+}
+
+// Backup archives and encrypts the org's local and home directories.
+func Backup(org, localDir, homeDir, passphrase string) ([]byte, *BackupManifest, error) {
+	manifest := &BackupManifest{Version: int(backupVersion), Org: org, Created: time.Now().Unix()}
+	contents := make(map[string][]byte)
+
+	if err := collectBackup(localDir, backupLocalDir, manifest, contents); err != nil {
+		return nil, nil, err
+	}
+	if err := collectBackup(homeDir, backupHomeDir, manifest, contents); err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool { return manifest.Files[i].Path < manifest.Files[j].Path })
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+
+	write := func(name string, mode int64, content []byte) error {
+		header := &tar.Header{Name: name, Mode: mode, Size: int64(len(content)), ModTime: exportModTime, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(content)
+		return err
+	}
+
+	if err := write(backupManifest, 0600, manifestJSON); err != nil {
+		return nil, nil, err
+	}
+	for _, file := range manifest.Files {
+		if err := write(file.Path, file.Mode, contents[file.Path]); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	header := new(bytes.Buffer)
+	header.WriteString(backupMagic)
+	header.WriteByte(backupVersion)
+	binary.Write(header, binary.BigEndian, backupIterations)
+
+	salt := make([]byte, backupSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+	header.Write(salt)
+
+	block, err := aes.NewCipher(backupKey(passphrase, salt, backupIterations))
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	header.Write(nonce)
+
+	return gcm.Seal(header.Bytes(), nonce, archive.Bytes(), header.Bytes()), manifest, nil
+}
+
+// OpenBackup decrypts a backup and checks every file against the manifest,
+// returning the manifest and the content of each file.
+func OpenBackup(backup []byte, passphrase string) (*BackupManifest, map[string][]byte, error) {
+	fixed := len(backupMagic) + 1 + 4 + backupSaltSize
+	if len(backup) < fixed || string(backup[:len(backupMagic)]) != backupMagic {
+		return nil, nil, fmt.Errorf("not a pki.io backup")
+	}
+
+	version := backup[len(backupMagic)]
+	if version != backupVersion {
+		return nil, nil, fmt.Errorf("unsupported backup version %d", version)
+	}
+
+	iterations := binary.BigEndian.Uint32(backup[len(backupMagic)+1:])
+	salt := backup[fixed-backupSaltSize : fixed]
+
+	block, err := aes.NewCipher(backupKey(passphrase, salt, iterations))
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(backup) < fixed+gcm.NonceSize() {
+		return nil, nil, fmt.Errorf("backup is truncated")
+	}
+	header := backup[:fixed+gcm.NonceSize()]
+	nonce := header[fixed:]
+
+	archive, err := gcm.Open(nil, nonce, backup[len(header):], header)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not decrypt backup, the passphrase is wrong or the file has been changed")
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, nil, err
+	}
+	tr := tar.NewReader(gz)
+
+	var manifest *BackupManifest
+	contents := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if header.Name == backupManifest {
+			manifest = new(BackupManifest)
+			if err := json.Unmarshal(content, manifest); err != nil {
+				return nil, nil, fmt.Errorf("could not parse backup manifest: %s", err)
+			}
+			continue
+		}
+		contents[header.Name] = content
+	}
+
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("backup has no manifest")
+	}
+	if err := checkBackupOrg(manifest.Org); err != nil {
+		return nil, nil, err
+	}
+
+	for _, file := range manifest.Files {
+		if err := checkBackupPath(file.Path); err != nil {
+			return nil, nil, err
+		}
+		content, ok := contents[file.Path]
+		if !ok {
+			return nil, nil, fmt.Errorf("'%s' is missing from the backup", file.Path)
+		}
+		sum := sha256.Sum256(content)
+		if hex.EncodeToString(sum[:]) != file.SHA256 {
+			return nil, nil, fmt.Errorf("'%s' doesn't match its checksum", file.Path)
+		}
+	}
+	if len(contents) != len(manifest.Files) {
+		return nil, nil, fmt.Errorf("backup has files that aren't in its manifest")
+	}
+
+	return manifest, contents, nil
+}
+
+// checkBackupPath rejects paths that would be restored outside the local and
+// home directories.
+func checkBackupPath(name string) error {
+	clean := path.Clean(name)
+	if clean != name || path.IsAbs(name) || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("invalid path in backup: %s", name)
+	}
+	if !strings.HasPrefix(name, backupLocalDir+"/") && !strings.HasPrefix(name, backupHomeDir+"/") {
+		return fmt.Errorf("invalid path in backup: %s", name)
+	}
+	return nil
+}
+
+// checkBackupOrg rejects org names that can't be used as the name of the
+// directory to restore into.
+func checkBackupOrg(org string) error {
+	if org == "" || org == "." || org == ".." || strings.ContainsAny(org, `/\`) {
+		return fmt.Errorf("invalid org name in backup: %q", org)
+	}
+	return nil
+}
+
+// checkFreshDir makes sure a restore won't mix with existing data.
+func checkFreshDir(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("'%s' isn't empty, restore into a new directory", dir)
+	}
+	return nil
+}
+
+// Restore writes the files of an opened backup into new local and home
+// directories. Both are written to temporary directories next to them and
+// only renamed into place once complete, so a failed restore leaves nothing
+// behind.
+func Restore(manifest *BackupManifest, contents map[string][]byte, localDir, homeDir string) error {
+	dirs := []string{localDir, homeDir}
+	prefixes := []string{backupLocalDir + "/", backupHomeDir + "/"}
+	var temps []string
+	defer func() {
+		for _, temp := range temps {
+			if temp != "" {
+				os.RemoveAll(temp)
+			}
+		}
+	}()
+
+	for _, dir := range dirs {
+		if err := checkFreshDir(dir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+			return err
+		}
+
+		temp, err := ioutil.TempDir(filepath.Dir(dir), "."+filepath.Base(dir))
+		if err != nil {
+			return err
+		}
+		temps = append(temps, temp)
+	}
+
+	for _, file := range manifest.Files {
+		for i, prefix := range prefixes {
+			if !strings.HasPrefix(file.Path, prefix) {
+				continue
+			}
+
+			target := filepath.Join(temps[i], filepath.FromSlash(strings.TrimPrefix(file.Path, prefix)))
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+
+			logger.Debugf("restoring '%s'", file.Path)
+			if err := ioutil.WriteFile(target, contents[file.Path], os.FileMode(file.Mode)&0777); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, dir := range dirs {
+		// checkFreshDir allows an empty directory, which has to go before
+		// the rename
+		if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Rename(temps[i], dir); err != nil {
+			if i > 0 {
+				os.RemoveAll(dirs[0])
+			}
+			return err
+		}
+		temps[i] = ""
+	}
+
+	return nil
+}
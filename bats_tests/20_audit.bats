@@ -0,0 +1,76 @@
+load "fixtures/basics"
+load "fixtures/audit"
+
+@test "audit list" {
+  init_init
+  init
+  $CMD ca new audit-ca
+  $CMD ca delete audit-ca --confirm-delete "no longer needed"
+  run audit_list
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q "org init"
+  echo "$output" | grep -q "ca new"
+  echo "$output" | grep -q "no longer needed"
+  run audit_list --action cert
+  [ "$status" -eq 0 ]
+  [[ "$output" != *"ca new"* ]]
+  cleanup
+}
+
+@test "audit private export" {
+  init_init
+  init
+  $CMD ca new audit-ca
+  $CMD ca show audit-ca --private > /dev/null
+  run audit_list --target audit-ca
+  echo "$output" | grep -q "ca show --private"
+  cleanup
+}
+
+@test "audit show" {
+  init_init
+  init
+  run audit_show 1
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q "org init"
+  run audit_show 100
+  [ "$status" -eq 1 ]
+  cleanup
+}
+
+@test "audit verify" {
+  init_init
+  init
+  $CMD ca new audit-ca
+  head=$(audit_head)
+  $CMD ca delete audit-ca --confirm-delete "this is just a test"
+  run audit_verify --head "$head"
+  [ "$status" -eq 0 ]
+  run audit_verify --head "0123456789abcdef"
+  [ "$status" -eq 1 ]
+  cleanup
+}
+
+@test "audit verify truncated" {
+  init_init
+  init
+  $CMD ca new audit-ca
+  cp cli/audit.json audit-before.json
+  $CMD ca delete audit-ca --confirm-delete "this is just a test"
+  run audit_verify
+  [ "$status" -eq 0 ]
+  cp audit-before.json cli/audit.json
+  run audit_verify
+  [ "$status" -eq 1 ]
+  echo "$output" | grep -q "removed"
+  cleanup
+}
+
+@test "audit before change" {
+  init_init
+  init
+  run $CMD ca delete no-such-ca --confirm-delete "this is just a test"
+  run audit_list --target no-such-ca
+  echo "$output" | grep -q "ca delete"
+  cleanup
+}
@@ -17,6 +17,37 @@ load "fixtures/ca"
   cleanup
 }
 
+@test "ca new intermediate" {
+  init_init
+  init
+  ca_new
+  run ca_new_intermediate
+  [ "$status" -eq 0 ]
+  cleanup
+}
+
+@test "ca new below path length 0" {
+  init_init
+  init
+  ca_new
+  ca_new_intermediate
+  run ca_new_below_intermediate
+  [ "$status" -ne 0 ]
+  cleanup
+}
+
+@test "ca show intermediate chain" {
+  init_init
+  init
+  ca_new
+  ca_new_intermediate
+  run ca_show_intermediate
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q "Chain certificates"
+  [ "$?" -eq 0 ]
+  cleanup
+}
+
 @test "ca list" {
   init_init
   init
@@ -26,6 +57,28 @@ load "fixtures/ca"
   cleanup
 }
 
+@test "ca list json" {
+  init_init
+  init
+  ca_new
+  run ca_list_json
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q "\"name\": \"$CA_NAME\""
+  [ "$?" -eq 0 ]
+  cleanup
+}
+
+@test "ca show yaml" {
+  init_init
+  init
+  ca_new
+  run ca_show_yaml
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q "^name: \"$CA_NAME\""
+  [ "$?" -eq 0 ]
+  cleanup
+}
+
 @test "ca exists" {
   init_init
   init
@@ -123,3 +176,31 @@ load "fixtures/ca"
   cleanup
 }
 
+@test "ca truststore p12" {
+  init_init
+  init
+  ca_new
+  run ca_truststore_p12
+  [ "$status" -eq 0 ]
+  openssl pkcs12 -in truststore.p12 -passin "pass:test pass" -nokeys | grep -q "friendlyName: $CA_NAME"
+  [ "$?" -eq 0 ]
+  cleanup
+}
+
+@test "ca truststore jks keytool" {
+  command -v keytool >/dev/null || skip "keytool isn't installed"
+  init_init
+  init
+  ca_new
+  ca_new_intermediate
+  run ca_truststore_jks
+  [ "$status" -eq 0 ]
+  run keytool -list -v -keystore truststore.jks -storetype JKS -storepass "test pass"
+  [ "$status" -eq 0 ]
+  [[ "$output" == *"Your keystore contains 2 entries"* ]]
+  [[ "$output" == *"Alias name: $CA_NAME"* ]]
+  [[ "$output" == *"Alias name: $CA_INTERMEDIATE_NAME"* ]]
+  [[ "$output" == *"Entry type: trustedCertEntry"* ]]
+  [[ "$output" != *"PrivateKeyEntry"* ]]
+  cleanup
+}
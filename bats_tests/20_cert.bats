@@ -36,6 +36,31 @@ load "fixtures/cert"
   cleanup
 }
 
+@test "cert new ca san" {
+  init_init
+  init
+  ca_new
+  run cert_new_ca_san
+  [ "$status" -eq 0 ]
+  run cert_text $CERT_NAME
+  [ "$status" -eq 0 ]
+  [[ "$output" == *"DNS:www.example.com, DNS:example.com, IP Address:127.0.0.1"* ]]
+  [[ "$output" == *"Digital Signature, Key Encipherment"* ]]
+  [[ "$output" == *"TLS Web Server Authentication"* ]]
+  cleanup
+}
+
+@test "cert new invalid key usage" {
+  init_init
+  init
+  ca_new
+  run $CMD cert new $CERT_NAME --ca $CA_NAME --key-usage signEverything
+  [ "$status" -eq 1 ]
+  run cert_check_exists $CERT_NAME
+  [ "$status" -ne 0 ]
+  cleanup
+}
+
 @test "cert new ca standalone" {
   init_init
   init
@@ -102,6 +127,33 @@ load "fixtures/cert"
   cleanup
 }
 
+@test "cert show export existing file" {
+  init_init
+  init
+  cert_new
+  run cert_show_export
+  [ "$status" -eq 0 ]
+  run cert_show_export
+  [ "$status" -ne 0 ]
+  run cert_show_export_force
+  [ "$status" -eq 0 ]
+  cleanup
+}
+
+@test "cert show export dir" {
+  init_init
+  init
+  ca_new
+  cert_new_ca
+  run cert_show_export_dir
+  [ "$status" -eq 0 ]
+  [ -f certs/${CERT_NAME}-cert.pem ]
+  [ -f certs/${CERT_NAME}-cacert.pem ]
+  [ -f certs/fullchain.pem ]
+  [ "$(stat -c %a certs/${CERT_NAME}.key)" = "600" ]
+  cleanup
+}
+
 @test "cert show export private" {
   init_init
   init
@@ -158,3 +210,176 @@ load "fixtures/cert"
   cleanup
 }
 
+@test "cert renew" {
+  init_init
+  init
+  ca_new
+  cert_new_ca
+  run cert_show
+  old_cert="$output"
+  run cert_renew
+  [ "$status" -eq 0 ]
+  run cert_show
+  [ "$output" != "$old_cert" ]
+  echo "$output" | grep -q "$CERT_TAG"
+  [ "$?" -eq 0 ]
+  run cert_show_history
+  echo "$output" | grep -q '"version": 1'
+  [ "$?" -eq 0 ]
+  cleanup
+}
+
+@test "cert renew revoked" {
+  init_init
+  init
+  ca_new
+  cert_new_ca
+  cert_revoke
+  run cert_renew
+  [ "$status" -eq 1 ]
+  [[ "$output" == *"revoked"* ]]
+  run cert_show_history
+  [[ "$output" != *'"version"'* ]]
+  cleanup
+}
+
+@test "cert revoke" {
+  init_init
+  init
+  ca_new
+  cert_new_ca
+  run cert_revoke
+  [ "$status" -eq 0 ]
+  run cert_show
+  echo "$output" | grep -q "revoked"
+  [ "$?" -eq 0 ]
+  cleanup
+}
+
+@test "cert revoke remove from crl" {
+  init_init
+  init
+  ca_new
+  cert_new_ca
+  run $CMD cert revoke $CERT_NAME --reason removeFromCRL
+  [ "$status" -eq 1 ]
+  echo "$output" | grep -q "invalid revocation reason"
+  cleanup
+}
+
+@test "ca crl revoked cert" {
+  init_init
+  init
+  ca_new
+  cert_new_ca
+  cert_revoke
+  run ca_crl
+  [ "$status" -eq 0 ]
+  openssl crl -in ${CA_NAME}-crl.pem -noout -text | grep -q "Key Compromise"
+  [ "$?" -eq 0 ]
+  cleanup
+}
+
+@test "ca ocsp-serve revoked cert" {
+  init_init
+  init
+  ca_new
+  cert_new_ca
+  cert_revoke
+  ca_ocsp_serve
+  run ca_ocsp_query
+  kill "$OCSP_PID"
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q "${CERT_NAME}-cert.pem: revoked"
+  [ "$?" -eq 0 ]
+  cleanup
+}
+
+@test "ca ocsp-serve deleted revoked cert" {
+  init_init
+  init
+  ca_new
+  cert_new_ca
+  ca_ocsp_export
+  cert_revoke
+  cert_delete
+  ca_ocsp_serve
+  run ca_ocsp_query_exported
+  kill "$OCSP_PID"
+  [ "$status" -eq 0 ]
+  [[ "$output" == *"${CERT_NAME}-cert.pem: revoked"* ]]
+  cleanup
+}
+
+@test "cert show export p12" {
+  init_init
+  init
+  ca_new
+  cert_new_ca
+  run cert_show_export_p12
+  [ "$status" -eq 0 ]
+  openssl pkcs12 -in ${CERT_NAME}.p12 -passin "pass:test pass" -nodes | grep -q "BEGIN PRIVATE KEY"
+  [ "$?" -eq 0 ]
+  cleanup
+}
+
+@test "cert show export pem bundle" {
+  init_init
+  init
+  ca_new
+  cert_new_ca
+  run cert_show_export_pem_bundle
+  [ "$status" -eq 0 ]
+  run sh -c "openssl crl2pkcs7 -nocrl -certfile ${CERT_NAME}-bundle.pem | openssl pkcs7 -print_certs -noout"
+  [ "$status" -eq 0 ]
+  [[ "$output" == *"subject="*"CN"*"$CERT_NAME"*"subject="*"CN"*"$CA_NAME"* ]]
+  run openssl pkey -in ${CERT_NAME}-bundle.pem -noout
+  [ "$status" -eq 0 ]
+  cleanup
+}
+
+@test "cert show export der" {
+  init_init
+  init
+  ca_new
+  cert_new_ca
+  run cert_show_export_der
+  [ "$status" -eq 0 ]
+  run openssl x509 -inform DER -in ${CERT_NAME}.der -noout -subject
+  [ "$status" -eq 0 ]
+  [[ "$output" == *"$CERT_NAME"* ]]
+  cleanup
+}
+
+@test "cert show export jks" {
+  init_init
+  init
+  ca_new
+  cert_new_ca
+  run cert_show_export_jks
+  [ "$status" -eq 0 ]
+  [ "$(head -c 4 ${CERT_NAME}.jks | od -An -tx1 | tr -d ' ')" = "feedfeed" ]
+  cleanup
+}
+
+@test "cert show export jks keytool" {
+  command -v keytool >/dev/null || skip "keytool isn't installed"
+  init_init
+  init
+  ca_new
+  cert_new_ca
+  run cert_show_export_jks
+  [ "$status" -eq 0 ]
+  run keytool -list -v -keystore ${CERT_NAME}.jks -storetype JKS -storepass "test pass"
+  [ "$status" -eq 0 ]
+  [[ "$output" == *"Alias name: service"* ]]
+  [[ "$output" == *"Entry type: PrivateKeyEntry"* ]]
+  [[ "$output" == *"Certificate chain length: 2"* ]]
+  # Converting the keystore decrypts the key, which checks its protection
+  run keytool -importkeystore -noprompt -srckeystore ${CERT_NAME}.jks -srcstoretype JKS -srcstorepass "test pass" -destkeystore ${CERT_NAME}-converted.p12 -deststoretype PKCS12 -deststorepass "test pass"
+  [ "$status" -eq 0 ]
+  run openssl pkcs12 -in ${CERT_NAME}-converted.p12 -passin "pass:test pass" -nodes
+  [ "$status" -eq 0 ]
+  [[ "$output" == *"PRIVATE KEY"* ]]
+  cleanup
+}
@@ -0,0 +1,41 @@
+load "fixtures/basics"
+load "fixtures/completion"
+
+@test "completion bash" {
+  run completion_script bash
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q "complete -F _pki_io pki.io"
+  completion_script bash | bash -n
+}
+
+@test "completion zsh" {
+  run completion_script zsh
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q "#compdef pki.io"
+}
+
+@test "completion fish" {
+  run completion_script fish
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q "__pki_io_at \"ca show\""
+}
+
+@test "completion bash subcommands" {
+  source <(completion_script bash)
+  COMP_WORDS=(pki.io ca "")
+  COMP_CWORD=2
+  _pki_io
+  [[ " ${COMPREPLY[*]} " == *" show "* ]]
+}
+
+@test "completion names" {
+  init_init
+  init
+  $CMD ca new completion-ca
+  run completion_names ca
+  [ "$status" -eq 0 ]
+  [ "$output" = "completion-ca" ]
+  run completion_names bogus
+  [ "$status" -eq 1 ]
+  cleanup
+}
@@ -0,0 +1,117 @@
+load "fixtures/basics"
+load "fixtures/config"
+
+@test "config set" {
+  config_init
+  run config_set key_type rsa
+  [ "$status" -eq 0 ]
+  run config_get key_type
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q '"value": "rsa"'
+  config_cleanup
+}
+
+@test "config set invalid" {
+  config_init
+  run config_set key_type dsa
+  [ "$status" -eq 1 ]
+  run config_set cert_expiry soon
+  [ "$status" -eq 1 ]
+  run config_set bogus 1
+  [ "$status" -eq 1 ]
+  config_cleanup
+}
+
+@test "config get unset" {
+  config_init
+  run config_get dn_o
+  [ "$status" -eq 1 ]
+  config_cleanup
+}
+
+@test "config profiles" {
+  config_init
+  config_set_profile work dn_o Acme
+  config_set_profile home dn_o Home
+  run $CMD --profile home --output json config get dn_o
+  echo "$output" | grep -q '"value": "Home"'
+  PKIIO_PROFILE=work run config_get dn_o
+  echo "$output" | grep -q '"value": "Acme"'
+  config_set default_profile home
+  run config_get dn_o
+  echo "$output" | grep -q '"value": "Home"'
+  run config_list
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q '"profile": "work"'
+  config_cleanup
+}
+
+@test "config set unknown profile" {
+  config_init
+  config_set_profile work dn_o Acme
+  run $CMD --profile wrok config set dn_o Typo
+  [ "$status" -eq 1 ]
+  echo "$output" | grep -q "\-\-new"
+  run $CMD --output json config list
+  [ "$status" -eq 0 ]
+  [[ "$output" != *"wrok"* ]]
+  run $CMD --profile wrok version
+  [ "$status" -eq 1 ]
+  [[ "$output" == *"isn't in"* ]]
+  config_cleanup
+}
+
+@test "config set invalid file" {
+  config_init
+  mkdir -p "$(dirname "$PKIIO_CONFIG")"
+  printf '[profile.default]\nkey_type = "dsa"\ndn_o = "Acme"\n' > "$PKIIO_CONFIG"
+  run $CMD version
+  [ "$status" -eq 1 ]
+  run config_set key_type ec
+  [ "$status" -eq 0 ]
+  run config_get dn_o
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q '"value": "Acme"'
+  config_cleanup
+}
+
+@test "config profile defaults" {
+  config_init
+  init_init
+  init
+  config_set output json
+  $CMD ca new profile-ca
+  run $CMD ca list
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q '"name": "profile-ca"'
+  cleanup
+  config_cleanup
+}
+
+@test "config profile local" {
+  config_init
+  init_init
+  init
+  config_set local "$PKIIO_LOCAL_DIR/$ORG"
+  cd /
+  unset PKIIO_LOCAL
+  run $CMD org show
+  [ "$status" -eq 0 ]
+  cleanup
+  config_cleanup
+}
+
+@test "config profile beats environment" {
+  config_init
+  init_init
+  init
+  config_set_profile work local "$PKIIO_LOCAL_DIR/$ORG"
+  cd /
+  export PKIIO_LOCAL="$PKIIO_LOCAL2_DIR"
+  run $CMD org show
+  [ "$status" -ne 0 ]
+  run $CMD --profile work org show
+  [ "$status" -eq 0 ]
+  cleanup
+  config_cleanup
+}
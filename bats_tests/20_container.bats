@@ -0,0 +1,48 @@
+load "fixtures/basics"
+load "fixtures/container"
+
+@test "container encrypt decrypt" {
+  init_init
+  init
+  run container_encrypt
+  [ "$status" -eq 0 ]
+  run grep -q "world" "$PKIIO_LOCAL2_DIR/doc.container"
+  [ "$status" -eq 1 ]
+  run container_decrypt
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q '"hello": "world"'
+  cleanup
+}
+
+@test "container verify" {
+  init_init
+  init
+  container_encrypt
+  run container_verify
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q "org $ORG"
+  sed -i 's/"Body":"./"Body":"A/' "$PKIIO_LOCAL2_DIR/doc.container"
+  run container_verify
+  [ "$status" -eq 1 ]
+  cleanup
+}
+
+@test "container inspect" {
+  init_init
+  init
+  container_encrypt
+  run container_inspect
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q "org $ORG"
+  [[ "$output" != *"world"* ]]
+  cleanup
+}
+
+@test "container not a container" {
+  init_init
+  init
+  echo "not json" > "$PKIIO_LOCAL2_DIR/doc.container"
+  run container_inspect
+  [ "$status" -eq 1 ]
+  cleanup
+}
@@ -1,6 +1,7 @@
 load "fixtures/basics"
 load "fixtures/ca"
 load "fixtures/csr"
+load "fixtures/cert"
 
 @test "csr new" {
   init_init
@@ -102,6 +103,76 @@ load "fixtures/csr"
   cleanup
 }
 
+@test "csr sign imported without key" {
+  init_init
+  init
+  ca_new
+  create_external_csr
+  csr_import
+  run csr_sign_keep_extensions
+  [ "$status" -eq 0 ]
+  run cert_text $CSR_NAME
+  [ "$status" -eq 0 ]
+  [[ "$output" == *"CN = $CSR_NAME"* ]]
+  cleanup
+}
+
+@test "csr sign keep extensions" {
+  init_init
+  init
+  ca_new
+  csr_new_san
+  run csr_sign_keep_extensions
+  [ "$status" -eq 0 ]
+  run cert_text $CSR_NAME
+  [[ "$output" == *"DNS:csr.example.com, IP Address:10.0.0.1"* ]]
+  [[ "$output" == *"Digital Signature"* ]]
+  [[ "$output" == *"TLS Web Client Authentication"* ]]
+  cleanup
+}
+
+@test "csr sign without extensions" {
+  init_init
+  init
+  ca_new
+  create_external_csr_san
+  csr_import
+  csr_sign
+  run cert_text $CSR_NAME
+  [ "$status" -eq 0 ]
+  [[ "$output" != *"requested.example.com"* ]]
+  cleanup
+}
+
+@test "csr sign keep extensions override" {
+  init_init
+  init
+  ca_new
+  create_external_csr_san
+  csr_import
+  run csr_sign_keep_extensions --dns override.example.com
+  [ "$status" -eq 0 ]
+  run cert_text $CSR_NAME
+  [[ "$output" == *"DNS:override.example.com"* ]]
+  [[ "$output" != *"requested.example.com"* ]]
+  [[ "$output" == *"TLS Web Client Authentication"* ]]
+  cleanup
+}
+
+@test "csr sign dn scope" {
+  init_init
+  init
+  ca_new_dnscope
+  create_external_csr_san
+  csr_import
+  run csr_sign_keep_extensions --keep-subject
+  [ "$status" -eq 0 ]
+  run cert_text $CSR_NAME
+  [[ "$output" == *"Subject: "*"O = ooo"*"CN = external"* ]]
+  [[ "$output" != *"O = external org"* ]]
+  cleanup
+}
+
 @test "csr sign list" {
   init_init
   init
@@ -9,6 +9,100 @@ load "fixtures/pairing_key"
   pairing_key_new
   run node_new
   [ "$status" -eq 0 ]
+  [[ "$output" == *"$NODENAME"* ]]
+  [[ "$output" != *"Public signing key"* ]]
+  cleanup
+}
+
+# Needs passwordless ssh to localhost, set PKIIO_TEST_NO_SSH=1 to skip it
+@test "node new host" {
+  [[ "$PKIIO_TEST_NO_SSH" != "1" ]] || skip "PKIIO_TEST_NO_SSH is set"
+  init_init
+  init
+  pairing_key_new
+  run node_new_host
+  [ "$status" -eq 0 ]
+  [ -x agent/bin/pki.io ]
+  echo "$output" | grep -q "register.*ok"
+  cleanup
+}
+
+@test "agent installer no systemd" {
+  init_init
+  init
+  pairing_key_new
+  run agent_install --no-systemd
+  [ "$status" -eq 0 ]
+  [ -x agent/bin/pki.io ]
+  [[ "$output" == *"STEP register ok"* ]]
+  [[ "$output" == *"STEP systemd-unit skipped disabled"* ]]
+  run org_run
+  run node_check_exists
+  [ "$status" -eq 0 ]
+  cleanup
+}
+
+@test "agent installer systemd" {
+  init_init
+  init
+  pairing_key_new
+  run agent_install_systemd
+  [ "$status" -eq 0 ]
+  [[ "$output" == *"STEP start-agent ok"* ]]
+  grep -q "^ExecStart=$PWD/agent/bin/pki.io node run $NODENAME --daemon" systemd-units/pki.io-agent.service
+  grep -q "^Environment=PKIIO_LOCAL=$PWD\$" systemd-units/pki.io-agent.service
+  grep -q "^enable --now pki.io-agent.service" systemctl.log
+  cleanup
+}
+
+@test "agent installer org not shared" {
+  init_init
+  init
+  pairing_key_new
+  run agent_install --no-systemd --local "$PWD/missing"
+  [ "$status" -ne 0 ]
+  [[ "$output" == *"STEP org-dir failed"* ]]
+  [ ! -e agent/bin/pki.io ]
+  cleanup
+}
+
+@test "node new expired pairing key" {
+  init_init
+  init
+  pairing_key_new_expiring 1s
+  sleep 2
+  node_new
+  run org_run
+  run node_check_exists
+  [ "$status" -ne 0 ]
+  run pairing_key_list
+  [ "$status" -eq 0 ]
+  [[ "$output" != *"$PAIRING_ID"* ]]
+  cleanup
+}
+
+@test "pairing key list expired" {
+  init_init
+  init
+  pairing_key_new_expiring 1s
+  sleep 2
+  run pairing_key_list
+  [ "$status" -eq 0 ]
+  [[ "$output" == *"$PAIRING_ID"* ]]
+  [[ "$output" == *"(expired)"* ]]
+  run pairing_key_list
+  [[ "$output" == *"$PAIRING_ID"* ]]
+  cleanup
+}
+
+@test "pairing key list expiry" {
+  init_init
+  init
+  pairing_key_new_expiring 24h
+  run pairing_key_list
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q "EXPIRES"
+  echo "$output" | grep -q "$PAIRING_ID"
   cleanup
 }
 
@@ -31,6 +125,19 @@ load "fixtures/pairing_key"
   cleanup
 }
 
+@test "node run daemon" {
+  init_init
+  init
+  pairing_key_new
+  node_new
+  org_run
+  run node_run_daemon
+  [ "$status" -eq 0 ]
+  grep -q '"state": "stopped"' node-status.json
+  grep -q '"last_error": ""' node-status.json
+  cleanup
+}
+
 @test "node check exists" {
   init_init
   init
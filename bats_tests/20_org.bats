@@ -18,3 +18,49 @@ load "fixtures/org"
   [ "$status" -eq 1 ]
   [[ "$output" =~ "Org config doesn't exist" ]]
 }
+
+@test "org backup" {
+  init_init
+  init
+  run org_backup
+  [ "$status" -eq 0 ]
+  [ -f "$PKIIO_LOCAL2_DIR/org.backup" ]
+  run org_backup
+  [ "$status" -eq 1 ]
+  run org_backup --force
+  [ "$status" -eq 0 ]
+  run org_backup_verify
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q "$ORG"
+  cleanup
+}
+
+@test "org backup verify tampered" {
+  init_init
+  init
+  org_backup
+  printf 'x' >> "$PKIIO_LOCAL2_DIR/org.backup"
+  run org_backup_verify
+  [ "$status" -eq 1 ]
+  BACKUP_PASSPHRASE="wrong" run org_backup_verify
+  [ "$status" -eq 1 ]
+  cleanup
+}
+
+@test "org restore" {
+  init_init
+  init
+  $CMD ca new backup-ca
+  org_backup
+  run org_restore
+  [ "$status" -eq 0 ]
+  cd "$PKIIO_LOCAL2_DIR/$ORG"
+  PKIIO_HOME="$PKIIO_HOME2_DIR/restored" run $CMD ca list
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q backup-ca
+  PKIIO_HOME="$PKIIO_HOME2_DIR/restored" run $CMD audit list
+  echo "$output" | grep -q "org restore"
+  run org_restore
+  [ "$status" -eq 1 ]
+  cleanup
+}
@@ -0,0 +1,37 @@
+load "fixtures/basics"
+load "fixtures/ca"
+load "fixtures/cert"
+load "fixtures/report"
+
+@test "report expiry nothing expiring" {
+  init_init
+  init
+  ca_new
+  cert_new_ca
+  run report_expiry
+  [ "$status" -eq 0 ]
+  cleanup
+}
+
+@test "report expiry expiring" {
+  init_init
+  init
+  ca_new
+  cert_new_ca
+  run report_expiry_within 400d
+  [ "$status" -eq 1 ]
+  echo "$output" | grep -q "$CERT_NAME"
+  [ "$?" -eq 0 ]
+  cleanup
+}
+
+@test "cert list expiring within" {
+  init_init
+  init
+  ca_new
+  cert_new_ca
+  run $CMD cert list --expiring-within 30d
+  [ "$status" -eq 0 ]
+  [[ "$output" != *"$CERT_NAME"* ]]
+  cleanup
+}
@@ -1,5 +1,7 @@
 load "fixtures/basics"
 load "fixtures/admin"
+load "fixtures/pairing_key"
+load "fixtures/node"
 
 @test "admin list" {
   init_init
@@ -90,3 +92,295 @@ load "fixtures/admin"
   [ "$status" -eq 0 ]
   cleanup
 }
+
+@test "admin invites" {
+  init_init
+  init
+  admin_invite
+  run admin_invites
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q "$INVITE_ID"
+  cleanup
+}
+
+@test "admin invite revoke" {
+  init_init
+  init
+  admin_invite
+  run admin_invite_revoke
+  [ "$status" -eq 0 ]
+  run admin_invites
+  [ "$status" -eq 0 ]
+  [[ "$output" != *"$INVITE_ID"* ]]
+  run admin_new
+  [ "$status" -ne 0 ]
+  [[ "$output" == *"expired or been revoked"* ]]
+  run admin_run
+  [ "$status" -eq 0 ]
+  run admin_check_exists "$ADMINNAME"
+  [ "$status" -eq 1 ]
+  cleanup
+}
+
+@test "admin invite revoked after new" {
+  init_init
+  init
+  admin_invite
+  admin_new
+  admin_invite_revoke
+  run admin_run
+  [ "$status" -eq 0 ]
+  [[ "$output" == *"expired or revoked"* ]]
+  run admin_check_exists "$ADMINNAME"
+  [ "$status" -eq 1 ]
+  run admin_complete
+  [ "$status" -ne 0 ]
+  [[ "$output" == *"expired or been revoked"* ]]
+  cleanup
+}
+
+@test "admin invite expired" {
+  init_init
+  init
+  admin_invite_expiring 1s
+  sleep 2
+  run admin_new
+  [ "$status" -ne 0 ]
+  [[ "$output" == *"expired or been revoked"* ]]
+  run admin_run
+  [ "$status" -eq 0 ]
+  run admin_check_exists "$ADMINNAME"
+  [ "$status" -eq 1 ]
+  cleanup
+}
+
+@test "admin invite revoke unknown" {
+  init_init
+  init
+  run $CMD admin invite-revoke nosuchinvite
+  [ "$status" -eq 1 ]
+  echo "$output" | grep -q "no pending invite"
+  cleanup
+}
+
+@test "admin role denied" {
+  init_init
+  init
+  admin_invite_role auditor
+  admin_join
+  run admin2 ca new denied-ca
+  [ "$status" -eq 1 ]
+  echo "$output" | grep -q "auditor role"
+  cleanup
+}
+
+@test "admin invite default role" {
+  init_init
+  init
+  admin_invite
+  admin_join
+  run $CMD admin show "$ADMINNAME"
+  echo "$output" | grep -q "auditor"
+  run admin2 ca new denied-ca
+  [ "$status" -eq 1 ]
+  cleanup
+}
+
+@test "admin role node cert private denied" {
+  init_init
+  init
+  pairing_key_new
+  node_new
+  admin_invite_role node-operator
+  admin_join
+  run admin2 node cert "$NODENAME" --export "$PWD/node.tar.gz" --private
+  [ "$status" -eq 1 ]
+  echo "$output" | grep -q "read-private"
+  cleanup
+}
+
+@test "admin role pairing key private denied" {
+  init_init
+  init
+  pairing_key_new
+  admin_invite_role issuer
+  admin_join
+  run admin2 pairing-key show "$PAIRING_ID" --private
+  [ "$status" -eq 1 ]
+  cleanup
+}
+
+@test "admin role scoped parent" {
+  init_init
+  init
+  $CMD ca new prod-ca --tags prod
+  admin_invite_role ca-operator dev
+  admin_join
+  run admin2 ca new dev-sub-ca --tags dev --parent prod-ca
+  [ "$status" -eq 1 ]
+  echo "$output" | grep -q "limited to tags"
+  cleanup
+}
+
+@test "admin delete last owner" {
+  init_init
+  init
+  run $CMD admin delete admin --confirm-delete "this is just a test"
+  [ "$status" -eq 1 ]
+  echo "$output" | grep -q "owner"
+  cleanup
+}
+
+# Orgs made before roles were kept have no roles document
+@test "admin roles missing" {
+  init_init
+  init
+  admin_invite
+  admin_join
+  rm cli/roles.json
+  run admin2 admin show admin2
+  [ "$status" -eq 0 ]
+  [[ "$output" == *"owner"* ]]
+  run admin_update_role auditor
+  [ "$status" -eq 0 ]
+  run $CMD admin show admin
+  [[ "$output" == *"owner"* ]]
+  run admin2 admin invite admin3
+  [ "$status" -ne 0 ]
+  cleanup
+}
+
+@test "admin role scoped" {
+  init_init
+  init
+  $CMD ca new dev-ca --tags dev
+  $CMD ca new prod-ca --tags prod
+  admin_invite_role issuer dev
+  admin_join
+  run admin2 cert new dev-cert --ca dev-ca
+  [ "$status" -eq 0 ]
+  run admin2 cert new prod-cert --ca prod-ca
+  [ "$status" -eq 1 ]
+  cleanup
+}
+
+@test "admin update role" {
+  init_init
+  init
+  admin_invite_role auditor
+  admin_join
+  run admin_update_role ca-operator
+  [ "$status" -eq 0 ]
+  run admin2 ca new allowed-ca
+  [ "$status" -eq 0 ]
+  cleanup
+}
+
+@test "admin audit signed" {
+  init_init
+  init
+  admin_invite_role ca-operator
+  admin_join
+  run admin2 ca new admin2-ca
+  [ "$status" -eq 0 ]
+  run $CMD audit list --admin "$ADMINNAME"
+  [[ "$output" == *"ca new"* ]]
+  run $CMD audit verify
+  [ "$status" -eq 0 ]
+  rm "$PKIIO_HOME2_DIR/.pki.io/cli/audit_signing.json"
+  run admin2 ca new other-ca
+  [ "$status" -ne 0 ]
+  [[ "$output" == *"another audit key"* ]]
+  cleanup
+}
+
+@test "admin quorum" {
+  init_init
+  init
+  run admin_quorum
+  [ "$status" -eq 0 ]
+  echo "$output" | grep -q "1"
+  admin_invite
+  admin_join
+  run admin_quorum 2
+  [ "$status" -eq 0 ]
+  cleanup
+}
+
+@test "admin approval" {
+  init_init
+  init
+  admin_invite
+  admin_join
+  admin_quorum 2
+  $CMD ca new approval-ca
+  status=0
+  ca_delete_request approval-ca || status=$?
+  [ "$status" -eq 2 ]
+  [ -n "$APPROVAL_ID" ]
+  $CMD ca list | grep -q approval-ca
+  run ca_delete_approved approval-ca
+  [ "$status" -eq 1 ]
+  echo "$output" | grep -q "quorum"
+  run admin2 admin run
+  echo "$output" | grep -q "$APPROVAL_ID"
+  run $CMD admin approve "$APPROVAL_ID"
+  [ "$status" -eq 1 ]
+  echo "$output" | grep -q "own requests"
+  run admin2 admin approve "$APPROVAL_ID"
+  [ "$status" -eq 0 ]
+  run ca_delete_approved approval-ca
+  [ "$status" -eq 0 ]
+  run $CMD ca list
+  [ "$status" -eq 0 ]
+  [[ "$output" != *approval-ca* ]]
+  cleanup
+}
+
+@test "admin approval kept on failure" {
+  init_init
+  init
+  admin_invite
+  admin_join
+  admin_quorum 2
+  $CMD ca new approval-ca
+  ca_show_private_request approval-ca || true
+  run admin2 admin approve "$APPROVAL_ID"
+  [ "$status" -eq 0 ]
+  run ca_show_private_approved approval-ca --export ca.tgz --export-format bogus
+  [ "$status" -eq 1 ]
+  run ca_show_private_approved approval-ca
+  [ "$status" -eq 0 ]
+  run ca_show_private_approved approval-ca
+  [ "$status" -eq 1 ]
+  [[ "$output" == *"already been used"* ]]
+  cleanup
+}
+
+@test "admin approval denied" {
+  init_init
+  init
+  admin_invite
+  admin_join
+  admin_quorum 2
+  $CMD ca new approval-ca
+  ca_delete_request approval-ca || true
+  run admin2 admin approve "$APPROVAL_ID" --deny
+  [ "$status" -eq 0 ]
+  run ca_delete_approved approval-ca
+  [ "$status" -eq 1 ]
+  echo "$output" | grep -q "denied"
+  cleanup
+}
+
+@test "org backup approval" {
+  init_init
+  init
+  admin_invite
+  admin_join
+  admin_quorum 2
+  BACKUP_PASSPHRASE="backup passphrase" run $CMD org backup --out "$PKIIO_LOCAL2_DIR/org.backup" --passphrase-env BACKUP_PASSPHRASE
+  [ "$status" -eq 2 ]
+  [ ! -f "$PKIIO_LOCAL2_DIR/org.backup" ]
+  cleanup
+}
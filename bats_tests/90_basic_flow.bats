@@ -17,6 +17,24 @@ load "fixtures/pairing_key"
   cleanup
 }
 
+@test "deploy flow" {
+  init_init
+  init
+  pairing_key_new
+  ca_new
+  node_new
+  org_run
+  node_deploy_config
+  run node_run_deploy
+  [ "$status" -eq 0 ]
+  ls deployed/*-cert.pem
+  [ "$(stat -c %a deployed/*-key.pem)" = "600" ]
+  run node_run_deploy
+  [ "$status" -eq 0 ]
+  [ "$(wc -l < deployed/hook.log)" -eq 1 ]
+  cleanup
+}
+
 @test "standalone cert" {
   init_init
   init
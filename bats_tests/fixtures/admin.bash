@@ -35,3 +35,65 @@ admin_check_exists() {
 admin_show() {
   $CMD admin show admin
 }
+
+admin_invite_expiring() {
+  output=$($CMD admin invite "$ADMINNAME" --expires-in "$1")
+  e="$?"
+  export INVITE_ID=$(echo "$output" | awk '/Id/ { print $4 }')
+  export INVITE_KEY=$(echo "$output" | awk '/Key/ { print $4 }')
+  return "$e"
+}
+
+admin_invites() {
+  $CMD admin invites
+}
+
+admin_invite_revoke() {
+  $CMD admin invite-revoke "$INVITE_ID"
+}
+
+admin_invite_role() {
+  output=$($CMD admin invite "$ADMINNAME" --role "$1" ${2:+--scope "$2"})
+  e="$?"
+  export INVITE_ID=$(echo "$output" | awk '/Id/ { print $4 }')
+  export INVITE_KEY=$(echo "$output" | awk '/Key/ { print $4 }')
+  return "$e"
+}
+
+admin_join() {
+  admin_new && admin_run && admin_complete
+}
+
+admin_update_role() {
+  $CMD admin update "$ADMINNAME" --role "$1"
+}
+
+admin2() {
+  PKIIO_HOME="$PKIIO_HOME2_DIR" $CMD "$@"
+}
+
+admin_quorum() {
+  $CMD admin quorum "$@"
+}
+
+ca_delete_request() {
+  output=$($CMD ca delete "$1" --confirm-delete "this is just a test")
+  e="$?"
+  export APPROVAL_ID=$(echo "$output" | awk '/Id/ { print $4 }')
+  return "$e"
+}
+
+ca_delete_approved() {
+  $CMD ca delete "$1" --confirm-delete "this is just a test" --approval "$APPROVAL_ID"
+}
+
+ca_show_private_request() {
+  output=$($CMD ca show "$1" --private)
+  e="$?"
+  export APPROVAL_ID=$(echo "$output" | awk '/Id/ { print $4 }')
+  return "$e"
+}
+
+ca_show_private_approved() {
+  $CMD ca show "$1" --private --approval "$APPROVAL_ID" "${@:2}"
+}
@@ -0,0 +1,15 @@
+audit_list() {
+  $CMD audit list "$@"
+}
+
+audit_show() {
+  $CMD audit show "$1"
+}
+
+audit_verify() {
+  $CMD audit verify "$@"
+}
+
+audit_head() {
+  audit_verify | awk '/Head/ { print $4 }'
+}
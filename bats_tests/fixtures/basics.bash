@@ -7,6 +7,10 @@ else
   export CMD="$SOURCE_PATH/pki.io"
 fi
 
+# Keep the user's own config profiles out of the tests
+export PKIIO_CONFIG="/dev/null"
+unset PKIIO_PROFILE
+
 if [[ ! -x "$CMD" ]]; then
   echo "Can't find pki.io binary at $CMD. Did you run 'make build'?"
   exit 1
@@ -2,6 +2,7 @@ export CA_NAME="testca"
 export CA_TAG="testtag"
 export CA_DN_ARG="--dn-l lll --dn-st stst --dn-o ooo --dn-ou ouou --dn-c ccc --dn-street street --dn-postal postal"
 export CA_EXTERNAL_CA_NAME="external-ca"
+export CA_INTERMEDIATE_NAME="testintca"
 
 create_external_ca() {
   openssl genrsa -out ${CA_EXTERNAL_CA_NAME}-key.pem 2048 >/dev/null 2>&1
@@ -16,10 +17,62 @@ ca_new_dnscope() {
   $CMD ca new $CA_NAME --tags $CA_TAG $CA_DN_ARG
 }
 
+ca_new_intermediate() {
+  $CMD ca new $CA_INTERMEDIATE_NAME --tags $CA_TAG --parent $CA_NAME --max-path-len 0
+}
+
+ca_new_below_intermediate() {
+  $CMD ca new sub-$CA_INTERMEDIATE_NAME --tags $CA_TAG --parent $CA_INTERMEDIATE_NAME
+}
+
+ca_show_intermediate() {
+  $CMD ca show $CA_INTERMEDIATE_NAME
+}
+
 ca_list() {
   $CMD ca list
 }
 
+ca_list_json() {
+  $CMD --output json ca list
+}
+
+ca_show_yaml() {
+  $CMD --output yaml ca show $CA_NAME
+}
+
+ca_truststore_p12() {
+  PKIIO_P12_PASS="test pass" $CMD ca truststore --export truststore.p12 --format p12 --ca $CA_NAME --passphrase-env PKIIO_P12_PASS
+}
+
+ca_truststore_jks() {
+  PKIIO_P12_PASS="test pass" $CMD ca truststore --export truststore.jks --format jks --ca $CA_NAME --ca $CA_INTERMEDIATE_NAME --passphrase-env PKIIO_P12_PASS
+}
+
+ca_crl() {
+  $CMD ca crl $CA_NAME --export ${CA_NAME}-crl.pem
+}
+
+ca_ocsp_serve() {
+  $CMD ca ocsp-serve $CA_NAME --listen 127.0.0.1:18080 &
+  export OCSP_PID="$!"
+  sleep 2
+}
+
+ca_ocsp_export() {
+  $CMD ca show $CA_NAME --export - | tar -xzO ${CA_NAME}-cert.pem > ${CA_NAME}-cert.pem
+  $CMD cert show $CERT_NAME --export - | tar -xzO ${CERT_NAME}-cert.pem > ${CERT_NAME}-cert.pem
+}
+
+ca_ocsp_query_exported() {
+  openssl ocsp -issuer ${CA_NAME}-cert.pem -CAfile ${CA_NAME}-cert.pem -cert ${CERT_NAME}-cert.pem -url http://127.0.0.1:18080
+}
+
+ca_ocsp_query() {
+  ca_ocsp_export
+  ca_ocsp_query_exported
+}
+
 ca_check_exists() {
   $CMD ca list | grep -q "$1"
 }
@@ -24,6 +24,14 @@ cert_new_ca() {
   $CMD cert new $CERT_NAME --tags $CERT_TAG --ca $CA_NAME
 }
 
+cert_new_ca_san() {
+  $CMD cert new $CERT_NAME --tags $CERT_TAG --ca $CA_NAME --dns www.example.com --dns example.com --ip 127.0.0.1 --key-usage digitalSignature --key-usage keyEncipherment --ext-key-usage serverAuth
+}
+
+cert_text() {
+  $CMD cert show $1 --export - | tar -xzO ${1}-cert.pem | openssl x509 -noout -text
+}
+
 cert_new_ca_standalone() {
   $CMD cert new $CERT_NAME --tags $CERT_TAG --ca $CA_NAME --standalone $CERT_EXPORT_FILE
 }
@@ -40,6 +48,18 @@ cert_delete() {
   $CMD cert delete $CERT_NAME --confirm-delete "this is just a test"
 }
 
+cert_renew() {
+  $CMD cert renew $CERT_NAME --expiry 30
+}
+
+cert_show_history() {
+  $CMD --output json cert show $CERT_NAME --history
+}
+
+cert_revoke() {
+  $CMD cert revoke $CERT_NAME --reason keyCompromise
+}
+
 cert_show() {
   $CMD cert show $CERT_NAME
 }
@@ -56,6 +76,30 @@ cert_show_export_private() {
   $CMD cert show $CERT_NAME --export $CERT_EXPORT_FILE --private
 }
 
+cert_show_export_dir() {
+  $CMD cert show $CERT_NAME --export-dir certs --private --fullchain --export-name "key={name}.key"
+}
+
+cert_show_export_force() {
+  $CMD cert show $CERT_NAME --export $CERT_EXPORT_FILE --force
+}
+
+cert_show_export_p12() {
+  PKIIO_P12_PASS="test pass" $CMD cert show $CERT_NAME --export ${CERT_NAME}.p12 --export-format p12 --private --passphrase-env PKIIO_P12_PASS
+}
+
+cert_show_export_pem_bundle() {
+  $CMD cert show $CERT_NAME --export ${CERT_NAME}-bundle.pem --export-format pem-bundle --private
+}
+
+cert_show_export_der() {
+  $CMD cert show $CERT_NAME --export ${CERT_NAME}.der --export-format der
+}
+
+cert_show_export_jks() {
+  PKIIO_P12_PASS="test pass" $CMD cert show $CERT_NAME --export ${CERT_NAME}.jks --export-format jks --alias service --private --passphrase-env PKIIO_P12_PASS
+}
+
 cert_import_public() {
   $CMD cert new $CERT_NAME --cert ${CERT_EXTERNAL_CERT_NAME}-cert.pem --tags $CERT_TAG
 }
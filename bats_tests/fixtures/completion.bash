@@ -0,0 +1,7 @@
+completion_script() {
+  $CMD completion "$1"
+}
+
+completion_names() {
+  $CMD completion names "$1"
+}
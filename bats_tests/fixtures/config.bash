@@ -0,0 +1,28 @@
+config_init() {
+  export PKIIO_CONFIG_DIR=$(mktemp -d 2>/dev/null || mktemp -d -t 'pkiiotmp')
+  export PKIIO_CONFIG="$PKIIO_CONFIG_DIR/pki.io/config.toml"
+}
+
+config_set() {
+  $CMD config set "$1" "$2"
+}
+
+config_set_profile() {
+  $CMD --profile "$1" config set "$2" "$3" --new
+}
+
+config_get() {
+  $CMD --output json config get "$1"
+}
+
+config_list() {
+  $CMD --output json config list
+}
+
+config_cleanup() {
+  if [[ "$NO_CLEAN" -ne "1" ]]; then
+    [ -d "$PKIIO_CONFIG_DIR" ] && rm -rf "$PKIIO_CONFIG_DIR"
+  fi
+  export PKIIO_CONFIG="/dev/null"
+  export PKIIO_CONFIG_DIR=""
+}
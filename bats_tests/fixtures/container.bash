@@ -0,0 +1,15 @@
+container_encrypt() {
+  echo '{"hello": "world"}' | $CMD container encrypt --out "$PKIIO_LOCAL2_DIR/doc.container"
+}
+
+container_decrypt() {
+  $CMD container decrypt "$PKIIO_LOCAL2_DIR/doc.container"
+}
+
+container_verify() {
+  $CMD container verify "$PKIIO_LOCAL2_DIR/doc.container"
+}
+
+container_inspect() {
+  $CMD container inspect < "$PKIIO_LOCAL2_DIR/doc.container"
+}
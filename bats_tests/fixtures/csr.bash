@@ -8,6 +8,11 @@ create_external_csr() {
   openssl req -new -batch -key ${CSR_EXTERNAL_CSR_NAME}-key.pem -out ${CSR_EXTERNAL_CSR_NAME}-csr.pem -days 365 >/dev/null 2>&1
 }
 
+create_external_csr_san() {
+  openssl genrsa -out ${CSR_EXTERNAL_CSR_NAME}-key.pem 2048 >/dev/null 2>&1
+  openssl req -new -batch -key ${CSR_EXTERNAL_CSR_NAME}-key.pem -out ${CSR_EXTERNAL_CSR_NAME}-csr.pem -subj "/CN=external/O=external org" -addext "subjectAltName=DNS:requested.example.com" -addext "extendedKeyUsage=clientAuth" >/dev/null 2>&1
+}
+
 csr_new() {
   $CMD csr new $CSR_NAME --tags $CSR_TAG
 }
@@ -60,6 +65,14 @@ csr_sign() {
   $CMD csr sign $CSR_NAME --ca $CA_NAME --tags $CSR_TAG
 }
 
+csr_new_san() {
+  $CMD csr new $CSR_NAME --tags $CSR_TAG --dns csr.example.com --ip 10.0.0.1 --key-usage digitalSignature --ext-key-usage clientAuth
+}
+
+csr_sign_keep_extensions() {
+  $CMD csr sign $CSR_NAME --ca $CA_NAME --tags $CSR_TAG --keep-extensions "$@"
+}
+
 csr_sign_standalone() {
   q
   $CMD csr sign $CSR_NAME ${CSR_EXTERNAL_CSR_NAME}-csr.pem --ca $CA_NAME --tags $CSR_TAG --standalone $CSR_EXPORT_FILE
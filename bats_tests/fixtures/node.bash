@@ -8,10 +8,61 @@ node_new_offline() {
   $CMD node new "$NODENAME" --pairing-id "$PAIRING_ID" --pairing-key "$PAIRING_KEY" --offline
 }
 
+node_new_host() {
+  PKIIO_PAIRING_KEY="$PAIRING_KEY" $CMD node new "$NODENAME" --pairing-id "$PAIRING_ID" \
+    --host localhost --install-file "$SOURCE_PATH/agent-installer.sh" \
+    --prefix "$PWD/agent" --data-dir "$PWD/agent-data" --no-systemd \
+    -- -o BatchMode=yes -o StrictHostKeyChecking=no
+}
+
+# Runs the installer directly on this host, as node new --host does over SSH
+agent_install() {
+  echo "$PAIRING_KEY" | "$SOURCE_PATH/agent-installer.sh" --name "$NODENAME" --pairing-id "$PAIRING_ID" \
+    --agent "$CMD" --local "$PWD" --home "$PKIIO_HOME" \
+    --prefix "$PWD/agent" --data-dir "$PWD/agent-data" "$@"
+}
+
+# Runs the installer as if systemd were running, with a systemctl that only
+# logs how it was called
+agent_install_systemd() {
+  mkdir -p fake-bin systemd-run systemd-units
+  cat > fake-bin/systemctl <<EOF
+#!/bin/sh
+echo "\$@" >> "$PWD/systemctl.log"
+EOF
+  chmod +x fake-bin/systemctl
+  PATH="$PWD/fake-bin:$PATH" SYSTEMD_RUN_DIR="$PWD/systemd-run" agent_install --unit-dir "$PWD/systemd-units"
+}
+
 node_run() {
   $CMD node run "$NODENAME"
 }
 
+node_deploy_config() {
+  cat > deploy.toml <<EOF
+[[target]]
+tags = ["testtag"]
+cert = "deployed/{name}-cert.pem"
+key = "deployed/{name}-key.pem"
+post_deploy = "echo reload >> deployed/hook.log"
+EOF
+}
+
+node_run_deploy() {
+  $CMD node run "$NODENAME" --deploy-config deploy.toml
+}
+
+node_run_daemon() {
+  $CMD node run "$NODENAME" --daemon --interval 1s --status-file node-status.json &
+  pid=$!
+  for i in $(seq 1 20); do
+    grep -q '"last_success": "2' node-status.json 2>/dev/null && break
+    sleep 0.5
+  done
+  kill -TERM $pid
+  wait $pid
+}
+
 node_delete() {
   $CMD node delete "$NODENAME" --confirm-delete "this is just a test"
 }
@@ -5,3 +5,17 @@ org_show() {
 org_run() {
   $CMD org run 
 }
+
+export BACKUP_PASSPHRASE="backup passphrase"
+
+org_backup() {
+  $CMD org backup --out "$PKIIO_LOCAL2_DIR/org.backup" --passphrase-env BACKUP_PASSPHRASE "$@"
+}
+
+org_backup_verify() {
+  $CMD org backup --verify "$PKIIO_LOCAL2_DIR/org.backup" --passphrase-env BACKUP_PASSPHRASE
+}
+
+org_restore() {
+  (cd "$PKIIO_LOCAL2_DIR" && $CMD org restore org.backup --home "$PKIIO_HOME2_DIR/restored" --passphrase-env BACKUP_PASSPHRASE)
+}
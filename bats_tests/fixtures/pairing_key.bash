@@ -5,3 +5,15 @@ pairing_key_new() {
   export PAIRING_KEY=$(echo "$output" | awk '/Key/ { print $4 }')
   return "$e"
 }
+
+pairing_key_new_expiring() {
+  output=$($CMD pairing-key new --tags testtag --expires-in "$1")
+  e="$?"
+  export PAIRING_ID=$(echo "$output" | awk '/Id/ { print $4 }')
+  export PAIRING_KEY=$(echo "$output" | awk '/Key/ { print $4 }')
+  return "$e"
+}
+
+pairing_key_list() {
+  $CMD pairing-key list
+}
@@ -0,0 +1,7 @@
+report_expiry() {
+  $CMD report expiry
+}
+
+report_expiry_within() {
+  $CMD report expiry --within "$1"
+}
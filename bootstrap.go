@@ -0,0 +1,176 @@
+// ThreatSpec package main
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// NodeBootstrap installs the agent on a remote host over SSH and registers it
+// as a node. Files are copied by piping them through ssh so that the same
+// SSH options work for every step.
+type NodeBootstrap struct {
+	Host        string
+	SSHOptions  []string
+	Prefix      string
+	DataDir     string
+	LocalDir    string
+	HomeDir     string
+	Interval    string
+	Sudo        bool
+	NoSystemd   bool
+	AgentFile   string
+	InstallFile string
+
+	steps []*OutputDoc
+}
+
+func NewNodeBootstrap(host string, sshOptions []string) *NodeBootstrap {
+	bootstrap := new(NodeBootstrap)
+	bootstrap.Host = host
+	bootstrap.SSHOptions = sshOptions
+	bootstrap.steps = []*OutputDoc{}
+	return bootstrap
+}
+
+// shellQuote quotes a string for the remote shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func (b *NodeBootstrap) step(name, status, detail string) {
+	logger.Infof("bootstrap step %s: %s %s", name, status, detail)
+	b.steps = append(b.steps, NewOutputDoc().
+		Add("step", "Step", name).
+		Add("status", "Status", status).
+		Add("detail", "Detail", detail))
+}
+
+// ssh runs a command on the remote host.
+func (b *NodeBootstrap) ssh(stdin io.Reader, command string) ([]byte, error) {
+	args := append(append([]string{}, b.SSHOptions...), b.Host, command)
+	logger.Debugf("running ssh %s", strings.Join(args, " "))
+
+	cmd := exec.Command("ssh", args...)
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return out, fmt.Errorf("%s: %s", err, msg)
+		}
+	}
+	return out, err
+}
+
+// upload copies a local file to path on the remote host.
+func (b *NodeBootstrap) upload(name, file, path string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		b.step(name, "failed", err.Error())
+		return err
+	}
+	defer f.Close()
+
+	if _, err := b.ssh(f, "cat > "+shellQuote(path)); err != nil {
+		b.step(name, "failed", err.Error())
+		return err
+	}
+
+	b.step(name, "ok", path)
+	return nil
+}
+
+// Install runs every bootstrap step, stopping at the first failure. The steps
+// are returned either way so the caller can report them.
+func (b *NodeBootstrap) Install(name, pairingId, pairingKey string) ([]*OutputDoc, error) {
+	if _, err := b.ssh(nil, "true"); err != nil {
+		b.step("connect", "failed", err.Error())
+		return b.steps, err
+	}
+	b.step("connect", "ok", b.Host)
+
+	out, err := b.ssh(nil, "mktemp -d")
+	if err != nil {
+		b.step("temp-dir", "failed", err.Error())
+		return b.steps, err
+	}
+	tmpDir := strings.TrimSpace(string(out))
+	b.step("temp-dir", "ok", tmpDir)
+
+	err = b.install(tmpDir, name, pairingId, pairingKey)
+
+	if _, cleanupErr := b.ssh(nil, "rm -rf "+shellQuote(tmpDir)); cleanupErr != nil {
+		b.step("cleanup", "failed", cleanupErr.Error())
+	} else {
+		b.step("cleanup", "ok", tmpDir)
+	}
+
+	return b.steps, err
+}
+
+func (b *NodeBootstrap) install(tmpDir, name, pairingId, pairingKey string) error {
+	agentPath := tmpDir + "/pki.io"
+	installPath := tmpDir + "/agent-installer.sh"
+
+	if err := b.upload("upload-agent", b.AgentFile, agentPath); err != nil {
+		return err
+	}
+
+	if err := b.upload("upload-installer", b.InstallFile, installPath); err != nil {
+		return err
+	}
+
+	command := []string{"sh", shellQuote(installPath),
+		"--name", shellQuote(name),
+		"--pairing-id", shellQuote(pairingId),
+		"--agent", shellQuote(agentPath),
+		"--prefix", shellQuote(b.Prefix),
+		"--data-dir", shellQuote(b.DataDir),
+		"--local", shellQuote(b.LocalDir),
+		"--interval", shellQuote(b.Interval)}
+	if b.HomeDir != "" {
+		command = append(command, "--home", shellQuote(b.HomeDir))
+	}
+	if b.NoSystemd {
+		command = append(command, "--no-systemd")
+	}
+	if b.Sudo {
+		// There is no terminal to ask for a password on, so fail instead
+		command = append([]string{"sudo", "-n"}, command...)
+	}
+
+	// The pairing key goes over stdin rather than on the command line
+	out, err := b.ssh(strings.NewReader(pairingKey+"\n"), strings.Join(command, " "))
+	b.installerSteps(out)
+	if err != nil {
+		b.step("install", "failed", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// installerSteps records the "STEP name status detail" lines printed by the
+// installer.
+func (b *NodeBootstrap) installerSteps(out []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 4)
+		if len(fields) < 3 || fields[0] != "STEP" {
+			continue
+		}
+		detail := ""
+		if len(fields) == 4 {
+			detail = fields[3]
+		}
+		b.step(fields[1], fields[2], detail)
+	}
+}
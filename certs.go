@@ -0,0 +1,259 @@
+// ThreatSpec package main
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"time"
+)
+
+// parseCertificate decodes the first certificate in a PEM string.
+func parseCertificate(pemData string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(pemData))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("could not decode PEM certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// isSelfSigned returns true if the certificate is its own issuer.
+func isSelfSigned(cert *x509.Certificate) bool {
+	if !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+		return false
+	}
+	return cert.CheckSignatureFrom(cert) == nil
+}
+
+// findIssuer returns the index of the certificate in candidates that signed
+// child, or -1 if there is none.
+func findIssuer(child *x509.Certificate, candidates []*x509.Certificate) int {
+	for i, candidate := range candidates {
+		if candidate == nil || candidate.Equal(child) {
+			continue
+		}
+		if !bytes.Equal(child.RawIssuer, candidate.RawSubject) {
+			continue
+		}
+		if child.CheckSignatureFrom(candidate) == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// issuerChain walks up from the certificate in certPEM and returns the indexes
+// of its issuers in candidates, from the immediate issuer up to the root. The
+// walk stops at a self-signed certificate or when no issuer can be found.
+func issuerChain(certPEM string, candidates []string) ([]int, error) {
+	var chain []int
+
+	current, err := parseCertificate(certPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	certs := make([]*x509.Certificate, len(candidates))
+	for i, c := range candidates {
+		// Unparsable candidates can't be issuers so just skip them
+		certs[i], _ = parseCertificate(c)
+	}
+
+	for len(chain) < len(certs) && !isSelfSigned(current) {
+		i := findIssuer(current, certs)
+		if i < 0 {
+			logger.Warnf("could not find issuer of '%s'", current.Subject.CommonName)
+			break
+		}
+		chain = append(chain, i)
+		current = certs[i]
+	}
+
+	return chain, nil
+}
+
+var oidCRLNumber = asn1.ObjectIdentifier{2, 5, 29, 20}
+
+// parseCRL decodes a PEM encoded certificate revocation list.
+func parseCRL(pemData string) (*pkix.CertificateList, error) {
+	block, _ := pem.Decode([]byte(pemData))
+	if block == nil || block.Type != "X509 CRL" {
+		return nil, fmt.Errorf("could not decode PEM CRL")
+	}
+	return x509.ParseDERCRL(block.Bytes)
+}
+
+// crlNumber returns the CRL number extension of a CRL, or nil if it has none.
+func crlNumber(crl *pkix.CertificateList) (*big.Int, error) {
+	for _, ext := range crl.TBSCertList.Extensions {
+		if ext.Id.Equal(oidCRLNumber) {
+			number := new(big.Int)
+			if _, err := asn1.Unmarshal(ext.Value, &number); err != nil {
+				return nil, err
+			}
+			return number, nil
+		}
+	}
+	return nil, nil
+}
+
+// revocationReasons are the RFC 5280 reasons a certificate can be revoked
+// for. removeFromCRL (8) is left out as it is only valid in delta CRLs.
+var revocationReasons = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"caCompromise":         2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"certificateHold":      6,
+	"privilegeWithdrawn":   9,
+	"aACompromise":         10,
+}
+
+// revocationReasonCode maps an RFC 5280 reason name to its CRLReason code.
+// Unknown reasons are treated as unspecified.
+func revocationReasonCode(reason string) int {
+	if code, ok := revocationReasons[reason]; ok {
+		return code
+	}
+	return 0
+}
+
+// parsePrivateKey decodes a PEM encoded RSA or EC private key in PKCS#1, SEC 1
+// or PKCS#8 form.
+func parsePrivateKey(pemData string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(pemData))
+	if block == nil {
+		return nil, fmt.Errorf("could not decode PEM private key")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type: %T", key)
+		}
+		return signer, nil
+	}
+}
+
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "digitalSignature"},
+	{x509.KeyUsageContentCommitment, "contentCommitment"},
+	{x509.KeyUsageKeyEncipherment, "keyEncipherment"},
+	{x509.KeyUsageDataEncipherment, "dataEncipherment"},
+	{x509.KeyUsageKeyAgreement, "keyAgreement"},
+	{x509.KeyUsageCertSign, "keyCertSign"},
+	{x509.KeyUsageCRLSign, "cRLSign"},
+	{x509.KeyUsageEncipherOnly, "encipherOnly"},
+	{x509.KeyUsageDecipherOnly, "decipherOnly"},
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "any",
+	x509.ExtKeyUsageServerAuth:      "serverAuth",
+	x509.ExtKeyUsageClientAuth:      "clientAuth",
+	x509.ExtKeyUsageCodeSigning:     "codeSigning",
+	x509.ExtKeyUsageEmailProtection: "emailProtection",
+	x509.ExtKeyUsageIPSECEndSystem:  "ipsecEndSystem",
+	x509.ExtKeyUsageIPSECTunnel:     "ipsecTunnel",
+	x509.ExtKeyUsageIPSECUser:       "ipsecUser",
+	x509.ExtKeyUsageTimeStamping:    "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+}
+
+func subjectAltNames(dnsNames []string, ips []net.IP, uris []*url.URL, emails []string) *OutputDoc {
+	ipStrings := []string{}
+	for _, ip := range ips {
+		ipStrings = append(ipStrings, ip.String())
+	}
+
+	uriStrings := []string{}
+	for _, uri := range uris {
+		uriStrings = append(uriStrings, uri.String())
+	}
+
+	if dnsNames == nil {
+		dnsNames = []string{}
+	}
+	if emails == nil {
+		emails = []string{}
+	}
+
+	return NewOutputDoc().
+		Add("dns", "DNS names", dnsNames).
+		Add("ip", "IP addresses", ipStrings).
+		Add("uri", "URIs", uriStrings).
+		Add("email", "Email addresses", emails)
+}
+
+// extensionsOutput describes the subject alternative names and key usages of
+// a certificate.
+func extensionsOutput(cert *x509.Certificate) *OutputDoc {
+	keyUsage := []string{}
+	for _, ku := range keyUsageNames {
+		if cert.KeyUsage&ku.usage != 0 {
+			keyUsage = append(keyUsage, ku.name)
+		}
+	}
+
+	extKeyUsage := []string{}
+	for _, eku := range cert.ExtKeyUsage {
+		if name, ok := extKeyUsageNames[eku]; ok {
+			extKeyUsage = append(extKeyUsage, name)
+		} else {
+			extKeyUsage = append(extKeyUsage, fmt.Sprintf("unknown(%d)", eku))
+		}
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		extKeyUsage = append(extKeyUsage, oid.String())
+	}
+
+	return subjectAltNames(cert.DNSNames, cert.IPAddresses, cert.URIs, cert.EmailAddresses).
+		Add("key_usage", "Key usage", keyUsage).
+		Add("ext_key_usage", "Extended key usage", extKeyUsage)
+}
+
+// parseCSR decodes a PEM encoded certificate signing request.
+func parseCSR(pemData string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(pemData))
+	if block == nil || (block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST") {
+		return nil, fmt.Errorf("could not decode PEM CSR")
+	}
+	return x509.ParseCertificateRequest(block.Bytes)
+}
+
+func issuerName(cert *x509.Certificate) string {
+	if cert.Issuer.CommonName != "" {
+		return cert.Issuer.CommonName
+	}
+	return cert.Issuer.String()
+}
+
+// addValidity adds the serial number, issuer and validity period of a
+// certificate to doc.
+func addValidity(doc *OutputDoc, cert *x509.Certificate) {
+	doc.Add("serial", "Serial", cert.SerialNumber.String()).
+		Add("issuer", "Issuer", issuerName(cert)).
+		Add("not_before", "Not before", cert.NotBefore.UTC().Format(time.RFC3339)).
+		Add("not_after", "Not after", cert.NotAfter.UTC().Format(time.RFC3339))
+}
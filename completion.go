@@ -0,0 +1,511 @@
+// ThreatSpec package main
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pki-io/controller"
+	"sort"
+	"strings"
+)
+
+// Completions of positional arguments and option values. Entity names are
+// looked up at completion time with `pki.io completion names TYPE`.
+const (
+	CompleteFlag  string = "flag"
+	CompleteFile  string = "file"
+	CompleteDir   string = "dir"
+	CompleteNames string = "names:"
+	CompleteWords string = "words:"
+)
+
+// completionEntry describes a command for the completion scripts. mow.cli
+// doesn't expose its command tree, so this has to be kept in step with the
+// commands. Options map long names to how their values complete, with
+// CompleteFlag for options that take no value.
+type completionEntry struct {
+	Path    string
+	Arg     string
+	Options map[string]string
+}
+
+var globalCompletionOptions = map[string]string{
+	"log-level": CompleteWords + "error warn info debug trace",
+	"logging":   CompleteFile,
+	"output":    CompleteWords + "table json yaml",
+	"profile":   CompleteNames + "profile",
+	"help":      CompleteFlag,
+}
+
+var globalCompletionShort = map[string]string{"l": "log-level", "o": "output"}
+
+var completionCmds = []completionEntry{
+	{"init", "", map[string]string{"admin": ""}},
+	{"admin", "", nil},
+	{"admin list", "", nil},
+	{"admin show", "names:admin", nil},
+	{"admin invite", "", map[string]string{"expires-in": "", "role": "words:owner ca-operator issuer auditor node-operator", "scope": ""}},
+	{"admin invites", "", nil},
+	{"admin invite-revoke", "names:invite", nil},
+	{"admin new", "", map[string]string{"invite-id": "", "invite-key": ""}},
+	{"admin run", "", nil},
+	{"admin complete", "", map[string]string{"invite-id": "", "invite-key": ""}},
+	{"admin update", "names:admin", map[string]string{"role": "words:owner ca-operator issuer auditor node-operator", "scope": "", "clear-scope": "flag"}},
+	{"admin approvals", "", map[string]string{"status": "words:pending approved denied completed"}},
+	{"admin approve", "names:approval", map[string]string{"deny": "flag"}},
+	{"admin quorum", "", map[string]string{"approval": ""}},
+	{"admin delete", "names:admin", map[string]string{"confirm-delete": ""}},
+	{"ca", "", nil},
+	{"ca new", "", map[string]string{"cert": "file", "key": "file", "tags": "", "ca-expiry": "", "cert-expiry": "", "key-type": "words:rsa ec", "parent": "names:ca", "max-path-len": "", "dn-l": "", "dn-st": "", "dn-o": "", "dn-ou": "", "dn-c": "", "dn-street": "", "dn-postal": ""}},
+	{"ca list", "", map[string]string{"expiring-within": "", "expired": "flag"}},
+	{"ca show", "names:ca", map[string]string{"export": "file", "private": "flag", "export-format": "words:tgz p12 jks pem-bundle der", "force": "flag", "export-dir": "dir", "fullchain": "flag", "export-name": "", "passphrase-env": "", "passphrase-file": "file", "approval": ""}},
+	{"ca update", "names:ca", map[string]string{"cert": "file", "key": "file", "tags": "", "ca-expiry": "", "cert-expiry": "", "dn-l": "", "dn-st": "", "dn-o": "", "dn-ou": "", "dn-c": "", "dn-street": "", "dn-postal": ""}},
+	{"ca crl", "names:ca", map[string]string{"export": "file", "next-update": "", "force": "flag"}},
+	{"ca ocsp-serve", "names:ca", map[string]string{"listen": "", "signer-cert": "file", "signer-key": "file", "cache-ttl": "", "path": ""}},
+	{"ca truststore", "", map[string]string{"export": "file", "format": "words:jks p12", "ca": "names:ca", "force": "flag", "passphrase-env": "", "passphrase-file": "file"}},
+	{"ca delete", "names:ca", map[string]string{"confirm-delete": "", "approval": ""}},
+	{"cert", "", nil},
+	{"cert new", "", map[string]string{"tags": "", "standalone": "file", "export-format": "words:tgz p12 jks pem-bundle der", "force": "flag", "export-dir": "dir", "fullchain": "flag", "export-name": "", "passphrase-env": "", "passphrase-file": "file", "cert": "file", "key": "file", "expiry": "", "ca": "names:ca", "key-type": "words:rsa ec", "dn-l": "", "dn-st": "", "dn-o": "", "dn-ou": "", "dn-c": "", "dn-street": "", "dn-postal": "", "dns": "", "ip": "", "uri": "", "email": "", "key-usage": "", "ext-key-usage": ""}},
+	{"cert list", "", map[string]string{"expiring-within": "", "expired": "flag"}},
+	{"cert show", "names:cert", map[string]string{"export": "file", "private": "flag", "history": "flag", "export-format": "words:tgz p12 jks pem-bundle der", "alias": "", "force": "flag", "export-dir": "dir", "fullchain": "flag", "export-name": "", "passphrase-env": "", "passphrase-file": "file"}},
+	{"cert update", "names:cert", map[string]string{"cert": "file", "key": "file", "tags": ""}},
+	{"cert renew", "names:cert", map[string]string{"expiry": "", "rekey": "flag"}},
+	{"cert revoke", "names:cert", map[string]string{"reason": "words:unspecified keyCompromise caCompromise affiliationChanged superseded cessationOfOperation certificateHold privilegeWithdrawn aACompromise"}},
+	{"cert delete", "names:cert", map[string]string{"confirm-delete": ""}},
+	{"csr", "", nil},
+	{"csr new", "", map[string]string{"tags": "", "standalone": "file", "csr": "file", "key": "file", "key-type": "words:rsa ec", "dn-l": "", "dn-st": "", "dn-o": "", "dn-ou": "", "dn-c": "", "dn-street": "", "dn-postal": "", "dns": "", "ip": "", "uri": "", "email": "", "key-usage": "", "ext-key-usage": "", "force": "flag"}},
+	{"csr list", "", nil},
+	{"csr show", "names:csr", map[string]string{"export": "file", "private": "flag", "force": "flag", "export-dir": "dir", "export-name": ""}},
+	{"csr sign", "names:csr", map[string]string{"keep-subject": "flag", "keep-extensions": "flag", "dns": "", "ip": "", "uri": "", "email": "", "key-usage": "", "ext-key-usage": "", "ca": "names:ca", "tags": ""}},
+	{"csr update", "names:csr", map[string]string{"csr": "file", "key": "file", "tags": ""}},
+	{"csr delete", "names:csr", map[string]string{"confirm-delete": ""}},
+	{"node", "", nil},
+	{"node new", "", map[string]string{"pairing-id": "", "pairing-key": "", "host": "", "agent-file": "file", "install-file": "file", "prefix": "dir", "data-dir": "dir", "remote-local": "dir", "remote-home": "dir", "interval": "", "sudo": "flag", "no-systemd": "flag"}},
+	{"node run", "names:node", map[string]string{"daemon": "flag", "interval": "", "max-backoff": "", "status-file": "file", "deploy-config": "file"}},
+	{"node cert", "names:node", map[string]string{"tags": "", "export": "file", "private": "flag"}},
+	{"node list", "", nil},
+	{"node show", "names:node", nil},
+	{"node delete", "names:node", map[string]string{"confirm-delete": ""}},
+	{"org", "", nil},
+	{"org list", "", nil},
+	{"org show", "", map[string]string{"private": "flag"}},
+	{"org run", "", nil},
+	{"org backup", "", map[string]string{"out": "file", "verify": "file", "force": "flag", "approval": "", "passphrase-env": "", "passphrase-file": "file"}},
+	{"org restore", "file", map[string]string{"local": "dir", "home": "dir", "passphrase-env": "", "passphrase-file": "file"}},
+	{"org delete", "names:org", map[string]string{"confirm-delete": "", "approval": ""}},
+	{"pairing-key", "", nil},
+	{"pairing-key new", "", map[string]string{"tags": "", "expires-in": ""}},
+	{"pairing-key list", "", nil},
+	{"pairing-key show", "names:pairing-key", map[string]string{"private": "flag"}},
+	{"pairing-key delete", "names:pairing-key", map[string]string{"confirm-delete": ""}},
+	{"report", "", nil},
+	{"report expiry", "", map[string]string{"within": "", "all": "flag"}},
+	{"audit", "", nil},
+	{"audit list", "", map[string]string{"action": "", "admin": "", "target": ""}},
+	{"audit show", "", nil},
+	{"audit verify", "", map[string]string{"head": ""}},
+	{"container", "", nil},
+	{"container encrypt", "file", map[string]string{"out": "file", "force": "flag"}},
+	{"container decrypt", "file", map[string]string{"out": "file", "force": "flag"}},
+	{"container verify", "file", nil},
+	{"container inspect", "file", nil},
+	{"version", "", nil},
+	{"config", "", nil},
+	{"config list", "", nil},
+	{"config get", "words:default_profile local home key_type ca_expiry cert_expiry dn_c dn_st dn_l dn_o dn_ou dn_street dn_postal log_level output", nil},
+	{"config set", "words:default_profile local home key_type ca_expiry cert_expiry dn_c dn_st dn_l dn_o dn_ou dn_street dn_postal log_level output", map[string]string{"new": "flag"}},
+	{"completion", "", nil},
+	{"completion bash", "", nil},
+	{"completion zsh", "", nil},
+	{"completion fish", "", nil},
+	{"completion names", "words:ca cert csr node admin pairing-key invite approval org profile", nil},
+}
+
+// completionChildren returns the subcommands of the command at path, with ""
+// for the top level.
+func completionChildren(path string) []string {
+	var children []string
+	for _, cmd := range completionCmds {
+		parent, name := "", cmd.Path
+		if i := strings.LastIndex(cmd.Path, " "); i >= 0 {
+			parent, name = cmd.Path[:i], cmd.Path[i+1:]
+		}
+		if parent == path {
+			children = append(children, name)
+		}
+	}
+	return children
+}
+
+func sortedOptions(options map[string]string) []string {
+	var names []string
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func completionOptionWords(options map[string]string) string {
+	var words []string
+	for _, name := range sortedOptions(options) {
+		words = append(words, "--"+name)
+	}
+	for _, name := range sortedOptions(globalCompletionOptions) {
+		words = append(words, "--"+name)
+	}
+	return strings.Join(words, " ")
+}
+
+// BashCompletion returns a bash completion script.
+func BashCompletion() string {
+	var b bytes.Buffer
+
+	b.WriteString(`# bash completion for pki.io, load with: source <(pki.io completion bash)
+
+_pki_io_names() {
+  pki.io -l error completion names "$1" 2>/dev/null
+}
+
+_pki_io_command() {
+  case "$1" in
+`)
+	for _, cmd := range completionCmds {
+		fmt.Fprintf(&b, "    %q) return 0 ;;\n", cmd.Path)
+	}
+	b.WriteString(`  esac
+  return 1
+}
+
+_pki_io_subcommands() {
+  case "$1" in
+`)
+	fmt.Fprintf(&b, "    \"\") echo %q ;;\n", strings.Join(completionChildren(""), " "))
+	for _, cmd := range completionCmds {
+		if children := completionChildren(cmd.Path); len(children) > 0 {
+			fmt.Fprintf(&b, "    %q) echo %q ;;\n", cmd.Path, strings.Join(children, " "))
+		}
+	}
+	b.WriteString(`  esac
+}
+
+_pki_io_options() {
+  case "$1" in
+`)
+	for _, cmd := range completionCmds {
+		fmt.Fprintf(&b, "    %q) echo %q ;;\n", cmd.Path, completionOptionWords(cmd.Options))
+	}
+	fmt.Fprintf(&b, "    *) echo %q ;;\n", completionOptionWords(nil))
+	b.WriteString(`  esac
+}
+
+_pki_io_arg() {
+  case "$1" in
+`)
+	for _, cmd := range completionCmds {
+		if cmd.Arg != "" {
+			fmt.Fprintf(&b, "    %q) echo %q ;;\n", cmd.Path, cmd.Arg)
+		}
+	}
+	b.WriteString(`  esac
+}
+
+# _pki_io_option prints how the value of an option completes and fails if the
+# option takes no value. It is given the command path and option together.
+_pki_io_option() {
+  case "$1" in
+`)
+	for _, name := range sortedOptions(globalCompletionOptions) {
+		if value := globalCompletionOptions[name]; value != CompleteFlag {
+			fmt.Fprintf(&b, "    *\" --%s\") echo %q ;;\n", name, value)
+		}
+	}
+	for short, name := range globalCompletionShort {
+		fmt.Fprintf(&b, "    *\" -%s\") echo %q ;;\n", short, globalCompletionOptions[name])
+	}
+	for _, cmd := range completionCmds {
+		for _, name := range sortedOptions(cmd.Options) {
+			if value := cmd.Options[name]; value != CompleteFlag {
+				fmt.Fprintf(&b, "    \"%s --%s\") echo %q ;;\n", cmd.Path, name, value)
+			}
+		}
+	}
+	b.WriteString(`    *) return 1 ;;
+  esac
+}
+
+_pki_io_complete() {
+  case "$1" in
+    file) COMPREPLY=($(compgen -f -- "$cur")) ;;
+    dir) COMPREPLY=($(compgen -d -- "$cur")) ;;
+    names:*) COMPREPLY=($(compgen -W "$(_pki_io_names "${1#names:}")" -- "$cur")) ;;
+    words:*) COMPREPLY=($(compgen -W "${1#words:}" -- "$cur")) ;;
+  esac
+}
+
+_pki_io() {
+  local cur prev word path spec skip i
+  COMPREPLY=()
+  cur="${COMP_WORDS[COMP_CWORD]}"
+  prev="${COMP_WORDS[COMP_CWORD-1]}"
+  path=""
+  skip=0
+
+  for ((i = 1; i < COMP_CWORD; i++)); do
+    word="${COMP_WORDS[i]}"
+    if [[ $skip -eq 1 ]]; then
+      skip=0
+      continue
+    fi
+    case "$word" in
+      -*=*) ;;
+      -*) _pki_io_option "$path $word" >/dev/null && skip=1 ;;
+      *) _pki_io_command "${path:+$path }$word" && path="${path:+$path }$word" ;;
+    esac
+  done
+
+  if [[ $COMP_CWORD -gt 1 ]] && spec=$(_pki_io_option "$path $prev"); then
+    _pki_io_complete "$spec"
+    return
+  fi
+
+  if [[ "$cur" == -* ]]; then
+    COMPREPLY=($(compgen -W "$(_pki_io_options "$path")" -- "$cur"))
+    return
+  fi
+
+  spec=$(_pki_io_subcommands "$path")
+  if [[ -n "$spec" ]]; then
+    COMPREPLY=($(compgen -W "$spec" -- "$cur"))
+    return
+  fi
+
+  _pki_io_complete "$(_pki_io_arg "$path")"
+}
+
+complete -F _pki_io pki.io
+`)
+
+	return b.String()
+}
+
+// ZshCompletion returns a zsh completion script, which runs the bash script
+// through zsh's bash completion support.
+func ZshCompletion() string {
+	return "#compdef pki.io\n# zsh completion for pki.io, load with: source <(pki.io completion zsh)\n\nautoload -U +X compinit && compinit\nautoload -U +X bashcompinit && bashcompinit\n\n" + BashCompletion()
+}
+
+// fishArg returns the fish complete options for a completion.
+func fishArg(value string) string {
+	switch {
+	case value == CompleteFile:
+		return "-r -F"
+	case value == CompleteDir:
+		return "-x -a '(__fish_complete_directories)'"
+	case strings.HasPrefix(value, CompleteNames):
+		return fmt.Sprintf("-x -a '(__pki_io_names %s)'", strings.TrimPrefix(value, CompleteNames))
+	case strings.HasPrefix(value, CompleteWords):
+		return fmt.Sprintf("-x -a '%s'", strings.TrimPrefix(value, CompleteWords))
+	default:
+		return "-x"
+	}
+}
+
+// FishCompletion returns a fish completion script.
+func FishCompletion() string {
+	var b bytes.Buffer
+
+	b.WriteString(`# fish completion for pki.io, load with: pki.io completion fish | source
+
+set -g __pki_io_commands`)
+	for _, cmd := range completionCmds {
+		fmt.Fprintf(&b, " %q", cmd.Path)
+	}
+	b.WriteString("\nset -g __pki_io_value_options")
+	for _, cmd := range completionCmds {
+		for _, name := range sortedOptions(cmd.Options) {
+			if cmd.Options[name] != CompleteFlag {
+				fmt.Fprintf(&b, " \"%s --%s\"", cmd.Path, name)
+			}
+		}
+	}
+	b.WriteString(`
+
+function __pki_io_names
+    pki.io -l error completion names $argv[1] 2>/dev/null
+end
+
+function __pki_io_path
+    set -l path ""
+    set -l skip 0
+    for word in (commandline -opc)[2..-1]
+        if test $skip -eq 1
+            set skip 0
+            continue
+        end
+        switch $word
+            case '-*=*'
+            case -l --log-level --logging -o --output --profile
+                set skip 1
+            case '-*'
+                if contains -- "$path $word" $__pki_io_value_options
+                    set skip 1
+                end
+            case '*'
+                set -l candidate (string trim -- "$path $word")
+                if contains -- $candidate $__pki_io_commands
+                    set path $candidate
+                end
+        end
+    end
+    echo $path
+end
+
+function __pki_io_at
+    set -l path (__pki_io_path)
+    test "$path" = "$argv[1]"
+end
+
+complete -c pki.io -f
+complete -c pki.io -s l -l log-level -x -a 'error warn info debug trace'
+complete -c pki.io -l logging -F
+complete -c pki.io -s o -l output -x -a 'table json yaml'
+complete -c pki.io -l profile -x -a '(__pki_io_names profile)'
+`)
+
+	fmt.Fprintf(&b, "complete -c pki.io -n '__pki_io_at \"\"' -a '%s'\n", strings.Join(completionChildren(""), " "))
+	for _, cmd := range completionCmds {
+		if children := completionChildren(cmd.Path); len(children) > 0 {
+			fmt.Fprintf(&b, "complete -c pki.io -n '__pki_io_at \"%s\"' -a '%s'\n", cmd.Path, strings.Join(children, " "))
+		}
+		if cmd.Arg != "" {
+			fmt.Fprintf(&b, "complete -c pki.io -n '__pki_io_at \"%s\"' %s\n", cmd.Path, fishArg(cmd.Arg))
+		}
+		for _, name := range sortedOptions(cmd.Options) {
+			value := cmd.Options[name]
+			if value == CompleteFlag {
+				fmt.Fprintf(&b, "complete -c pki.io -n '__pki_io_at \"%s\"' -l %s\n", cmd.Path, name)
+			} else {
+				fmt.Fprintf(&b, "complete -c pki.io -n '__pki_io_at \"%s\"' -l %s %s\n", cmd.Path, name, fishArg(value))
+			}
+		}
+	}
+
+	return b.String()
+}
+
+// completionNames lists the names, or ids for pairing keys, invites and
+// approval requests, of the given entity type, or the config's profiles.
+func completionNames(app *AdminApp, kind string) ([]string, error) {
+	var names []string
+
+	switch kind {
+	case "profile":
+		return userConfig.ProfileNames(), nil
+	case "ca":
+		cont, err := controller.NewCA(app.env)
+		if err != nil {
+			return nil, err
+		}
+		cas, err := cont.List(controller.NewCAParams())
+		if err != nil {
+			return nil, err
+		}
+		for _, ca := range cas {
+			names = append(names, ca.Name())
+		}
+	case "cert":
+		cont, err := controller.NewCertificate(app.env)
+		if err != nil {
+			return nil, err
+		}
+		certs, err := cont.List(controller.NewCertificateParams())
+		if err != nil {
+			return nil, err
+		}
+		for _, cert := range certs {
+			names = append(names, cert.Name())
+		}
+	case "csr":
+		cont, err := controller.NewCSR(app.env)
+		if err != nil {
+			return nil, err
+		}
+		csrs, err := cont.List(controller.NewCSRParams())
+		if err != nil {
+			return nil, err
+		}
+		for _, csr := range csrs {
+			names = append(names, csr.Name())
+		}
+	case "node":
+		cont, err := controller.NewNode(app.env)
+		if err != nil {
+			return nil, err
+		}
+		nodes, err := cont.List(controller.NewNodeParams())
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range nodes {
+			names = append(names, node.Name())
+		}
+	case "admin":
+		cont, err := controller.NewAdmin(app.env)
+		if err != nil {
+			return nil, err
+		}
+		admins, err := cont.List(controller.NewAdminParams())
+		if err != nil {
+			return nil, err
+		}
+		for _, admin := range admins {
+			names = append(names, admin.Name())
+		}
+	case "org":
+		cont, err := controller.NewOrg(app.env)
+		if err != nil {
+			return nil, err
+		}
+		orgs, err := cont.List(controller.NewOrgParams())
+		if err != nil {
+			return nil, err
+		}
+		for _, org := range orgs {
+			names = append(names, org.Name())
+		}
+	case "invite":
+		invites := make(Invites)
+		if _, err := app.Store().Load(invitesDoc, &invites); err != nil {
+			return nil, err
+		}
+		for id, invite := range invites {
+			if !invite.Used && !invite.Revoked {
+				names = append(names, id)
+			}
+		}
+	case "approval":
+		approvals, err := loadApprovals(app)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, approvals.Ids(ApprovalPending)...)
+	case "pairing-key":
+		cont, err := controller.NewPairingKey(app.env)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := cont.List(controller.NewPairingKeyParams())
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			names = append(names, row[0])
+		}
+	default:
+		return nil, fmt.Errorf("invalid completion type: %s", kind)
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
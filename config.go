@@ -0,0 +1,269 @@
+// ThreatSpec package main
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/mitchellh/go-homedir"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Profile settings. The local and home settings are only used when
+// PKIIO_LOCAL and PKIIO_HOME aren't set.
+const (
+	ProfileLocal      string = "local"
+	ProfileHome       string = "home"
+	ProfileKeyType    string = "key_type"
+	ProfileCAExpiry   string = "ca_expiry"
+	ProfileCertExpiry string = "cert_expiry"
+	ProfileDnCountry  string = "dn_c"
+	ProfileDnState    string = "dn_st"
+	ProfileDnLocality string = "dn_l"
+	ProfileDnOrg      string = "dn_o"
+	ProfileDnOrgUnit  string = "dn_ou"
+	ProfileDnStreet   string = "dn_street"
+	ProfileDnPostal   string = "dn_postal"
+	ProfileLogLevel   string = "log_level"
+	ProfileOutput     string = "output"
+)
+
+// ConfigDefaultProfile names the profile used when none is given.
+const ConfigDefaultProfile string = "default_profile"
+
+type profileSetting struct {
+	Name  string
+	Int   bool
+	Check func(value string) error
+}
+
+func checkWord(kind string, words ...string) func(string) error {
+	return func(value string) error {
+		for _, word := range words {
+			if value == word {
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid %s: %s", kind, value)
+	}
+}
+
+var profileSettings = []profileSetting{
+	{Name: ProfileLocal},
+	{Name: ProfileHome},
+	{Name: ProfileKeyType, Check: checkWord("key type", "ec", "rsa")},
+	{Name: ProfileCAExpiry, Int: true},
+	{Name: ProfileCertExpiry, Int: true},
+	{Name: ProfileDnCountry},
+	{Name: ProfileDnState},
+	{Name: ProfileDnLocality},
+	{Name: ProfileDnOrg},
+	{Name: ProfileDnOrgUnit},
+	{Name: ProfileDnStreet},
+	{Name: ProfileDnPostal},
+	{Name: ProfileLogLevel, Check: checkWord("log level", "error", "warn", "info", "debug", "trace")},
+	{Name: ProfileOutput, Check: checkOutputFormat},
+}
+
+func findProfileSetting(name string) (*profileSetting, error) {
+	for i := range profileSettings {
+		if profileSettings[i].Name == name {
+			return &profileSettings[i], nil
+		}
+	}
+	return nil, fmt.Errorf("invalid config key: %s", name)
+}
+
+// Profile is a named set of defaults. A nil profile has no settings.
+type Profile map[string]interface{}
+
+func (p Profile) String(name, fallback string) string {
+	if value, ok := p[name]; ok {
+		return fmt.Sprint(value)
+	}
+	return fallback
+}
+
+func (p Profile) Int(name string, fallback int) int {
+	if value, ok := p[name]; ok {
+		switch v := value.(type) {
+		case int64:
+			return int(v)
+		case int:
+			return v
+		}
+	}
+	return fallback
+}
+
+// Set checks and stores a setting, keeping integer settings as integers in
+// the file.
+func (p Profile) Set(name, value string) error {
+	setting, err := findProfileSetting(name)
+	if err != nil {
+		return err
+	}
+
+	if setting.Int {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid %s: %s", name, value)
+		}
+		p[name] = n
+		return nil
+	}
+
+	if setting.Check != nil {
+		if err := setting.Check(value); err != nil {
+			return err
+		}
+	}
+	p[name] = value
+	return nil
+}
+
+// Apply points PKIIO_LOCAL and PKIIO_HOME at the profile's directories. If
+// they are already set they are kept, unless the profile was chosen with
+// --profile, which names the org more explicitly than the environment does.
+func (p Profile) Apply(explicit bool) error {
+	for name, env := range map[string]string{ProfileLocal: "PKIIO_LOCAL", ProfileHome: "PKIIO_HOME"} {
+		if _, ok := os.LookupEnv(env); ok && !explicit {
+			continue
+		}
+		if value := p.String(name, ""); value != "" {
+			path, err := homedir.Expand(value)
+			if err != nil {
+				return err
+			}
+			if err := os.Setenv(env, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Config is the user's config file of named profiles.
+//
+//	default_profile = "work"
+//
+//	[profile.work]
+//	local = "~/pki/acme"
+//	home = "~/pki/home"
+//	key_type = "rsa"
+//	cert_expiry = 90
+//	dn_o = "Acme"
+type Config struct {
+	DefaultProfile string             `toml:"default_profile"`
+	Profiles       map[string]Profile `toml:"profile"`
+}
+
+// ConfigPath returns $PKIIO_CONFIG if set, otherwise config.toml in the
+// pki.io directory under $XDG_CONFIG_HOME or ~/.config.
+func ConfigPath() (string, error) {
+	if path := os.Getenv("PKIIO_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := homedir.Dir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "pki.io", "config.toml"), nil
+}
+
+// LoadConfig reads the config file, which needn't exist. A file with invalid
+// settings is returned without them, along with an error, so that the config
+// commands can still fix it.
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{Profiles: make(map[string]Profile)}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return config, nil
+	}
+
+	if _, err := toml.DecodeFile(path, config); err != nil {
+		return nil, fmt.Errorf("could not load config '%s': %s", path, err)
+	}
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]Profile)
+	}
+
+	// Check every setting, storing them as Set would so that "90" and 90
+	// both work for an expiry.
+	var invalid error
+	for name, profile := range config.Profiles {
+		checked := make(Profile)
+		for key, value := range profile {
+			if err := checked.Set(key, fmt.Sprint(value)); err != nil && invalid == nil {
+				invalid = fmt.Errorf("profile '%s' in '%s': %s", name, path, err)
+			}
+		}
+		config.Profiles[name] = checked
+	}
+
+	return config, invalid
+}
+
+// Save writes the config file, which may hold paths, readable only by the
+// user.
+func (c *Config) Save(path string) error {
+	var content bytes.Buffer
+	if err := toml.NewEncoder(&content).Encode(c); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return WriteExport(content.Bytes(), path, 0600, -1, -1, true)
+}
+
+// ProfileName picks the profile to use: the --profile option, then
+// $PKIIO_PROFILE, then the config's default profile, then "default".
+func (c *Config) ProfileName(option string) string {
+	for _, name := range []string{option, os.Getenv("PKIIO_PROFILE"), c.DefaultProfile} {
+		if name != "" {
+			return name
+		}
+	}
+	return "default"
+}
+
+// ProfileNames returns the config's profiles in order.
+func (c *Config) ProfileNames() []string {
+	var names []string
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// rootArgs finds --profile among the root options and the command that
+// follows them. The profile has to be known before mow.cli parses anything
+// because it sets the defaults of the options being declared.
+func rootArgs(args []string) (profile, command string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--profile" && i+1 < len(args):
+			profile = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--profile="):
+			profile = strings.TrimPrefix(arg, "--profile=")
+		case arg == "-l" || arg == "--log-level" || arg == "--logging" || arg == "-o" || arg == "--output":
+			i++
+		case !strings.HasPrefix(arg, "-"):
+			return profile, arg
+		}
+	}
+	return profile, ""
+}
@@ -0,0 +1,393 @@
+// ThreatSpec package main
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/pki-io/controller"
+	"github.com/pki-io/core/x509"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DeployTarget maps node certificates with any of the given tags to files on
+// disk. Paths may use {name} for the certificate name, which they must when
+// the tags match more than one certificate, and empty paths aren't written.
+//
+//	[[target]]
+//	tags = ["web"]
+//	cert = "/etc/nginx/ssl/{name}.crt"
+//	key = "/etc/nginx/ssl/{name}.key"
+//	fullchain = "/etc/nginx/ssl/{name}-fullchain.crt"
+//	key_mode = "0640"
+//	group = "nginx"
+//	post_deploy = "systemctl reload nginx"
+type DeployTarget struct {
+	Tags       []string `toml:"tags"`
+	Cert       string   `toml:"cert"`
+	Key        string   `toml:"key"`
+	CACert     string   `toml:"cacert"`
+	Fullchain  string   `toml:"fullchain"`
+	CertMode   string   `toml:"cert_mode"`
+	KeyMode    string   `toml:"key_mode"`
+	Owner      string   `toml:"owner"`
+	Group      string   `toml:"group"`
+	PostDeploy string   `toml:"post_deploy"`
+}
+
+// DeployConfig is the node-side deployment config.
+type DeployConfig struct {
+	Targets []DeployTarget `toml:"target"`
+}
+
+func LoadDeployConfig(path string) (*DeployConfig, error) {
+	config := new(DeployConfig)
+	if _, err := toml.DecodeFile(path, config); err != nil {
+		return nil, fmt.Errorf("could not load deploy config '%s': %s", path, err)
+	}
+
+	for i, target := range config.Targets {
+		if len(target.Tags) == 0 {
+			return nil, fmt.Errorf("deploy target %d has no tags", i+1)
+		}
+		if _, _, err := target.ids(); err != nil {
+			return nil, err
+		}
+		if _, err := parseMode(target.CertMode, 0644); err != nil {
+			return nil, err
+		}
+		if _, err := parseMode(target.KeyMode, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+func parseMode(mode string, defaultMode int64) (int64, error) {
+	if mode == "" {
+		return defaultMode, nil
+	}
+	value, err := strconv.ParseInt(mode, 8, 32)
+	if err != nil || value < 0 || value > 0777 {
+		return 0, fmt.Errorf("invalid file mode: %s", mode)
+	}
+	return value, nil
+}
+
+// ids looks up the numeric owner and group of a target, with -1 leaving them
+// unchanged.
+func (t *DeployTarget) ids() (int64, int64, error) {
+	var uid, gid int64 = -1, -1
+
+	if t.Owner != "" {
+		u, err := user.Lookup(t.Owner)
+		if err != nil {
+			return 0, 0, err
+		}
+		if uid, err = strconv.ParseInt(u.Uid, 10, 64); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if t.Group != "" {
+		g, err := user.LookupGroup(t.Group)
+		if err != nil {
+			return 0, 0, err
+		}
+		if gid, err = strconv.ParseInt(g.Gid, 10, 64); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// key identifies a target in the deploy state by its tags and paths, so that
+// a fixed post deploy command is still run after a failure.
+func (t *DeployTarget) key() string {
+	return strings.Join(append(append([]string{}, t.Tags...), t.Cert, t.Key, t.CACert, t.Fullchain), "|")
+}
+
+// files returns the files a certificate is deployed to by the target.
+func (t *DeployTarget) files(cert *x509.Certificate) ([]ExportFile, error) {
+	certMode, _ := parseMode(t.CertMode, 0644)
+	keyMode, _ := parseMode(t.KeyMode, 0600)
+	uid, gid, err := t.ids()
+	if err != nil {
+		return nil, err
+	}
+
+	body := cert.Data.Body
+	fullchain := strings.TrimSpace(body.Certificate) + "\n"
+	if body.CACertificate != "" {
+		fullchain += strings.TrimSpace(body.CACertificate) + "\n"
+	}
+
+	var files []ExportFile
+	for _, f := range []struct {
+		path    string
+		mode    int64
+		content string
+	}{
+		{t.Cert, certMode, body.Certificate},
+		{t.CACert, certMode, body.CACertificate},
+		{t.Fullchain, certMode, fullchain},
+		{t.Key, keyMode, body.PrivateKey},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if f.content == "" {
+			logger.Warnf("nothing to deploy to '%s' for certificate '%s'", f.path, body.Name)
+			continue
+		}
+		path := strings.Replace(f.path, "{name}", body.Name, -1)
+		files = append(files, ExportFile{Name: path, Mode: f.mode, Owner: uid, Group: gid, Content: []byte(f.content)})
+	}
+
+	return files, nil
+}
+
+// DeployedFile is the mode and owner a file was last deployed with.
+type DeployedFile struct {
+	Mode  int64 `json:"mode"`
+	Owner int64 `json:"owner"`
+	Group int64 `json:"group"`
+}
+
+// DeployState is what the previous deploys did, kept next to the deploy
+// config so that a changed mode or owner is applied and a failed post deploy
+// command is run again on the next deploy.
+type DeployState struct {
+	Files        map[string]DeployedFile `json:"files"`
+	PendingHooks map[string]bool         `json:"pending_hooks"`
+}
+
+func deployStateFile(deployFile string) string {
+	return deployFile + ".state"
+}
+
+// LoadDeployState reads the state of a deploy config, which is empty before
+// the first deploy.
+func LoadDeployState(deployFile string) (*DeployState, error) {
+	state := &DeployState{Files: make(map[string]DeployedFile), PendingHooks: make(map[string]bool)}
+
+	content, err := ioutil.ReadFile(deployStateFile(deployFile))
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("could not load deploy state '%s': %s", deployStateFile(deployFile), err)
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]DeployedFile)
+	}
+	if state.PendingHooks == nil {
+		state.PendingHooks = make(map[string]bool)
+	}
+	return state, nil
+}
+
+func (s *DeployState) Save(deployFile string) error {
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteExport(append(content, '\n'), deployStateFile(deployFile), 0600, -1, -1, true)
+}
+
+// deployFile writes a file if its content differs from what is on disk or it
+// was last deployed with a different mode or owner, and returns whether it
+// was written.
+func deployFile(file ExportFile, state *DeployState) (bool, error) {
+	deployed := DeployedFile{Mode: file.Mode, Owner: file.Owner, Group: file.Group}
+
+	current, err := ioutil.ReadFile(file.Name)
+	if last, ok := state.Files[file.Name]; ok && last == deployed && err == nil && bytes.Equal(current, file.Content) {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file.Name), 0755); err != nil {
+		return false, err
+	}
+
+	logger.Infof("deploying '%s'", file.Name)
+	if err := WriteExport(file.Content, file.Name, os.FileMode(file.Mode), file.Owner, file.Group, true); err != nil {
+		return false, err
+	}
+	state.Files[file.Name] = deployed
+	return true, nil
+}
+
+// CertSource returns the node's certificates with any of the given tags.
+type CertSource func(tags []string) ([]*x509.Certificate, error)
+
+// Deploy writes the certificates with a target's tags to the target. A
+// target's post deploy command is only run if one of its files changed, or if
+// it failed last time. It returns every certificate deployed and records what
+// was done in state, which should be saved even if it fails.
+func (c *DeployConfig) Deploy(source CertSource, state *DeployState) ([]*x509.Certificate, error) {
+	var deployed []*x509.Certificate
+	seen := make(map[string]bool)
+
+	for i, target := range c.Targets {
+		changed := state.PendingHooks[target.key()]
+
+		certs, err := source(target.Tags)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(certs) > 1 {
+			for _, path := range []string{target.Cert, target.Key, target.CACert, target.Fullchain} {
+				if path != "" && !strings.Contains(path, "{name}") {
+					return nil, fmt.Errorf("deploy target %d matches %d certificates but '%s' has no {name}", i+1, len(certs), path)
+				}
+			}
+		}
+
+		for _, cert := range certs {
+			if !seen[cert.Data.Body.Name] {
+				seen[cert.Data.Body.Name] = true
+				deployed = append(deployed, cert)
+			}
+
+			files, err := target.files(cert)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, file := range files {
+				written, err := deployFile(file, state)
+				if err != nil {
+					return nil, err
+				}
+				changed = changed || written
+			}
+		}
+
+		if changed && target.PostDeploy != "" {
+			logger.Infof("running post deploy command '%s'", target.PostDeploy)
+			state.PendingHooks[target.key()] = true
+			out, err := exec.Command("/bin/sh", "-c", target.PostDeploy).CombinedOutput()
+			if err != nil {
+				return nil, fmt.Errorf("post deploy command '%s' failed: %s: %s", target.PostDeploy, err, strings.TrimSpace(string(out)))
+			}
+			delete(state.PendingHooks, target.key())
+		}
+	}
+
+	return deployed, nil
+}
+
+// nodeCerts returns the certificates a node holds with any of tags. The node
+// controller only exports them, as a tar.gz of NAME-cert.pem, NAME-key.pem and
+// NAME-cacert.pem files, so the export is read back.
+func nodeCerts(cont *controller.NodeController, name string, tags []string) ([]*x509.Certificate, error) {
+	dir, err := ioutil.TempDir("", "pkiio")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	private := true
+	export := filepath.Join(dir, "certs.tar.gz")
+	tagList := strings.Join(tags, ",")
+
+	params := controller.NewNodeParams()
+	params.Name = &name
+	params.Tags = &tagList
+	params.Export = &export
+	params.Private = &private
+
+	if err := cont.Cert(params); err != nil {
+		return nil, err
+	}
+
+	archive, err := ioutil.ReadFile(export)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	files, err := readTarGZ(archive)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the certificates of node '%s': %s", name, err)
+	}
+
+	var certs []*x509.Certificate
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name, "-cert.pem") {
+			continue
+		}
+		certName := strings.TrimSuffix(file.Name, "-cert.pem")
+
+		cert := new(x509.Certificate)
+		cert.Data.Body.Name = certName
+		cert.Data.Body.Tags = tags
+		cert.Data.Body.Certificate = string(file.Content)
+		for _, other := range files {
+			switch other.Name {
+			case certName + "-key.pem":
+				cert.Data.Body.PrivateKey = string(other.Content)
+			case certName + "-cacert.pem":
+				cert.Data.Body.CACertificate = string(other.Content)
+			}
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// runNode runs a node's tasks and, if deployFile is given, deploys the node's
+// certificates with it. It returns the certificates deployed, or without a
+// deploy file those tagged with the node's name.
+func runNode(app *AdminApp, params *controller.NodeParams, deployFile string) ([]*x509.Certificate, error) {
+	cont, err := controller.NewNode(app.env)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cont.Run(params); err != nil {
+		return nil, err
+	}
+
+	source := func(tags []string) ([]*x509.Certificate, error) {
+		return nodeCerts(cont, *params.Name, tags)
+	}
+
+	if deployFile == "" {
+		return source([]string{*params.Name})
+	}
+
+	config, err := LoadDeployConfig(deployFile)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := LoadDeployState(deployFile)
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := config.Deploy(source, state)
+	if saveErr := state.Save(deployFile); saveErr != nil {
+		logger.Errorf("could not save deploy state: %s", saveErr)
+		if err == nil {
+			err = saveErr
+		}
+	}
+	return certs, err
+}
@@ -0,0 +1,98 @@
+// ThreatSpec package main
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseWindow parses a time window given in days ("30d"), weeks ("2w") or as
+// a Go duration ("12h").
+func parseWindow(window string) (time.Duration, error) {
+	var unit time.Duration
+	switch {
+	case strings.HasSuffix(window, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(window, "w"):
+		unit = 7 * 24 * time.Hour
+	default:
+		d, err := time.ParseDuration(window)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("invalid time window: %s", window)
+		}
+		return d, nil
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(window[:len(window)-1]))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid time window: %s", window)
+	}
+
+	return time.Duration(n) * unit, nil
+}
+
+// ExpiryFilter matches certificates that have expired or will expire within
+// a time window.
+type ExpiryFilter struct {
+	within  time.Duration
+	expired bool
+	now     time.Time
+}
+
+// NewExpiryFilter creates a filter from the --expiring-within and --expired
+// options. An empty window and expired set to false match everything.
+func NewExpiryFilter(within string, expired bool) (*ExpiryFilter, error) {
+	filter := new(ExpiryFilter)
+	filter.expired = expired
+	filter.now = time.Now()
+	filter.within = -1
+
+	if within != "" {
+		duration, err := parseWindow(within)
+		if err != nil {
+			return nil, err
+		}
+		filter.within = duration
+	}
+
+	return filter, nil
+}
+
+func (filter *ExpiryFilter) Active() bool {
+	return filter.expired || filter.within >= 0
+}
+
+func (filter *ExpiryFilter) IsExpired(notAfter time.Time) bool {
+	return !filter.now.Before(notAfter)
+}
+
+func (filter *ExpiryFilter) IsExpiring(notAfter time.Time) bool {
+	return !filter.IsExpired(notAfter) && filter.within >= 0 && notAfter.Before(filter.now.Add(filter.within))
+}
+
+func (filter *ExpiryFilter) Match(notAfter time.Time) bool {
+	if !filter.Active() {
+		return true
+	}
+	return (filter.expired && filter.IsExpired(notAfter)) || filter.IsExpiring(notAfter)
+}
+
+// Status describes a certificate's expiry as expired, expiring or valid.
+func (filter *ExpiryFilter) Status(notAfter time.Time) string {
+	switch {
+	case filter.IsExpired(notAfter):
+		return "expired"
+	case filter.IsExpiring(notAfter):
+		return "expiring"
+	default:
+		return "valid"
+	}
+}
+
+// DaysLeft returns the number of whole days until notAfter, which is negative
+// once it has passed.
+func (filter *ExpiryFilter) DaysLeft(notAfter time.Time) int {
+	return int(notAfter.Sub(filter.now).Hours() / 24)
+}
@@ -0,0 +1,622 @@
+// ThreatSpec package main
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"golang.org/x/crypto/ssh/terminal"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"software.sslmate.com/src/go-pkcs12"
+	"strings"
+	"time"
+)
+
+const (
+	ExportTGZ       string = "tgz"
+	ExportP12       string = "p12"
+	ExportPEMBundle string = "pem-bundle"
+	ExportDER       string = "der"
+	ExportJKS       string = "jks"
+)
+
+// What an ExportFile holds, so that formats other than tgz know which files
+// make up the certificate, its chain and its key.
+const (
+	ExportFileCert   string = "cert"
+	ExportFileCACert string = "cacert"
+	ExportFileKey    string = "key"
+	ExportFileCSR    string = "csr"
+)
+
+// Exported files all get the same modification time so that exporting the same
+// data twice gives identical archives.
+var exportModTime = time.Unix(0, 0)
+
+// ExportFile is a single file in an export. Owner and Group are the numeric
+// ids of archived and deployed files, with -1 leaving the owner of deployed
+// files unchanged.
+type ExportFile struct {
+	Name    string
+	Type    string
+	Mode    int64
+	Owner   int64
+	Group   int64
+	Content []byte
+}
+
+// NewExportFile returns a file to export that keeps the owner and group of
+// whoever exports it.
+func NewExportFile(name, fileType string, mode int64, content []byte) ExportFile {
+	return ExportFile{Name: name, Type: fileType, Mode: mode, Owner: -1, Group: -1, Content: content}
+}
+
+// ExportParams holds the export options shared by commands that export.
+type ExportParams struct {
+	Format     *string
+	Alias      *string
+	Force      *bool
+	Passphrase *Passphrase
+
+	// Directory exports
+	Dir       *string
+	Fullchain *bool
+	Names     *[]string
+}
+
+func NewExportParams() *ExportParams {
+	format := ExportTGZ
+	alias := ""
+	force := false
+	dir := ""
+	fullchain := false
+	names := []string{}
+	return &ExportParams{
+		Format:     &format,
+		Alias:      &alias,
+		Force:      &force,
+		Passphrase: new(Passphrase),
+		Dir:        &dir,
+		Fullchain:  &fullchain,
+		Names:      &names,
+	}
+}
+
+// ExportFileFullchain names the certificate followed by its CA chain, which
+// is only written by directory exports.
+const ExportFileFullchain string = "fullchain"
+
+// nameTemplates parses the TYPE=TEMPLATE file name templates of a directory
+// export.
+func (params *ExportParams) nameTemplates() (map[string]string, error) {
+	templates := make(map[string]string)
+	for _, name := range *params.Names {
+		parts := strings.SplitN(name, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid export name '%s', expected TYPE=TEMPLATE", name)
+		}
+
+		switch parts[0] {
+		case ExportFileCert, ExportFileCACert, ExportFileKey, ExportFileCSR, ExportFileFullchain:
+			templates[parts[0]] = parts[1]
+		default:
+			return nil, fmt.Errorf("invalid export file type: %s", parts[0])
+		}
+	}
+	return templates, nil
+}
+
+// Check validates the export options before anything is loaded.
+func (params *ExportParams) Check(outFile string) error {
+	if *params.Dir != "" && outFile != "" {
+		return fmt.Errorf("can't export to both a file and a directory")
+	}
+	if _, err := params.nameTemplates(); err != nil {
+		return err
+	}
+	return checkExportFormat(*params.Format)
+}
+
+func checkExportFormat(format string) error {
+	switch format {
+	case ExportTGZ, ExportP12, ExportPEMBundle, ExportDER, ExportJKS:
+		return nil
+	default:
+		return fmt.Errorf("invalid export format: %s", format)
+	}
+}
+
+// Passphrase reads an export passphrase from an environment variable, a file
+// or, if neither is given, by prompting on the terminal.
+type Passphrase struct {
+	Env  *string
+	File *string
+}
+
+func (p *Passphrase) Read() (string, error) {
+	if p.Env != nil && *p.Env != "" {
+		value, ok := os.LookupEnv(*p.Env)
+		if !ok {
+			return "", fmt.Errorf("passphrase environment variable %s is not set", *p.Env)
+		}
+		return value, nil
+	}
+
+	if p.File != nil && *p.File != "" {
+		content, err := ioutil.ReadFile(*p.File)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return "", fmt.Errorf("no passphrase given and stdin is not a terminal")
+	}
+
+	fmt.Fprint(os.Stderr, "Export passphrase: ")
+	first, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+	second, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	if !bytes.Equal(first, second) {
+		return "", fmt.Errorf("passphrases don't match")
+	}
+
+	return string(first), nil
+}
+
+// archiveId returns an owner or group id for an archive header, where there
+// is no unchanged owner to leave alone.
+func archiveId(id int64) int {
+	if id < 0 {
+		return 0
+	}
+	return int(id)
+}
+
+// readTarGZ returns the regular files in a tar.gz archive.
+func readTarGZ(archive []byte) ([]ExportFile, error) {
+	zipReader, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	tarReader := tar.NewReader(zipReader)
+
+	var files []ExportFile
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		content, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, ExportFile{Name: header.Name, Mode: header.Mode, Content: content})
+	}
+	return files, nil
+}
+
+func TarGZ(files []ExportFile) ([]byte, error) {
+	tarBuffer := new(bytes.Buffer)
+	tarWriter := tar.NewWriter(tarBuffer)
+
+	for _, file := range files {
+		header := &tar.Header{
+			Name:    file.Name,
+			Mode:    file.Mode,
+			Uid:     archiveId(file.Owner),
+			Gid:     archiveId(file.Group),
+			Size:    int64(len(file.Content)),
+			ModTime: exportModTime,
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tarWriter.Write(file.Content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return nil, err
+	}
+
+	zipBuffer := new(bytes.Buffer)
+	zipWriter := gzip.NewWriter(zipBuffer)
+	if _, err := zipWriter.Write(tarBuffer.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := zipWriter.Close(); err != nil {
+		return nil, err
+	}
+
+	return zipBuffer.Bytes(), nil
+}
+
+func filesOfType(files []ExportFile, fileType string) []ExportFile {
+	var result []ExportFile
+	for _, file := range files {
+		if file.Type == fileType {
+			result = append(result, file)
+		}
+	}
+	return result
+}
+
+// pemCertificates decodes every certificate in the given files.
+func pemCertificates(files []ExportFile) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, file := range files {
+		rest := file.Content
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
+		}
+	}
+	return certs, nil
+}
+
+func PEMBundle(files []ExportFile) []byte {
+	buffer := new(bytes.Buffer)
+	for _, fileType := range []string{ExportFileCert, ExportFileCSR, ExportFileCACert, ExportFileKey} {
+		for _, file := range filesOfType(files, fileType) {
+			buffer.Write(bytes.TrimSpace(file.Content))
+			buffer.WriteString("\n")
+		}
+	}
+	return buffer.Bytes()
+}
+
+func DER(files []ExportFile) ([]byte, error) {
+	certs, err := pemCertificates(filesOfType(files, ExportFileCert))
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("nothing to export as DER")
+	}
+	return certs[0].Raw, nil
+}
+
+func PKCS12(files []ExportFile, passphrase string) ([]byte, error) {
+	certs, err := pemCertificates(filesOfType(files, ExportFileCert))
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate to export")
+	}
+
+	keys := filesOfType(files, ExportFileKey)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("p12 export needs the private key, try adding --private")
+	}
+
+	key, err := parsePrivateKey(string(keys[0].Content))
+	if err != nil {
+		return nil, err
+	}
+
+	caCerts, err := pemCertificates(filesOfType(files, ExportFileCACert))
+	if err != nil {
+		return nil, err
+	}
+
+	// 3DES rather than AES as older Windows and Java releases can't read the
+	// modern encryption
+	return pkcs12.LegacyDES.Encode(key, certs[0], caCerts, passphrase)
+}
+
+// JKS builds a Java keystore holding the private key and certificate chain
+// under alias. The key is protected with the keystore passphrase, as most Java
+// tools expect.
+func JKS(files []ExportFile, alias, passphrase string) ([]byte, error) {
+	certs, err := pemCertificates(filesOfType(files, ExportFileCert))
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate to export")
+	}
+
+	keys := filesOfType(files, ExportFileKey)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jks export needs the private key, try adding --private")
+	}
+
+	key, err := parsePrivateKey(string(keys[0].Content))
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	caCerts, err := pemCertificates(filesOfType(files, ExportFileCACert))
+	if err != nil {
+		return nil, err
+	}
+
+	chain := append([]*x509.Certificate{certs[0]}, caCerts...)
+	return encodeJKS([]jksEntry{{alias: alias, key: keyDER, chain: chain}}, passphrase)
+}
+
+// TrustStore builds a JKS or PKCS#12 truststore from the CA certificate
+// files, using each file name as the alias of its certificates.
+func TrustStore(files []ExportFile, format, passphrase string) ([]byte, error) {
+	var jksEntries []jksEntry
+	var entries []pkcs12.TrustStoreEntry
+
+	for _, file := range filesOfType(files, ExportFileCACert) {
+		certs, err := pemCertificates([]ExportFile{file})
+		if err != nil {
+			return nil, err
+		}
+
+		for i, cert := range certs {
+			alias := file.Name
+			if i > 0 {
+				alias = fmt.Sprintf("%s-%d", file.Name, i)
+			}
+
+			entries = append(entries, pkcs12.TrustStoreEntry{Cert: cert, FriendlyName: alias})
+			jksEntries = append(jksEntries, jksEntry{alias: alias, chain: []*x509.Certificate{cert}})
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no CA certificates for the truststore")
+	}
+
+	switch format {
+	case ExportJKS:
+		return encodeJKS(jksEntries, passphrase)
+	case ExportP12:
+		return pkcs12.LegacyDES.EncodeTrustStoreEntries(entries, passphrase)
+	default:
+		return nil, fmt.Errorf("invalid truststore format: %s", format)
+	}
+}
+
+// WriteExport writes exported content to outFile, or to stdout for "-". The
+// content is written to a temporary file which is renamed into place, so a
+// failed export never leaves a partial file behind. An existing file is only
+// replaced if force is set. The file is chowned to owner and group, with -1
+// leaving either unchanged.
+func WriteExport(content []byte, outFile string, mode os.FileMode, owner, group int64, force bool) error {
+	if outFile == "-" {
+		_, err := os.Stdout.Write(content)
+		return err
+	}
+
+	tmp, err := writeTemp(outFile, content, mode, owner, group)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	return placeFile(tmp, outFile, force)
+}
+
+// placeFile moves a temporary file to path. Unless force is set it fails if
+// path exists, as it links the file rather than renaming it, so a file created
+// by someone else after we started is never replaced.
+func placeFile(tmp, path string, force bool) error {
+	if force {
+		return os.Rename(tmp, path)
+	}
+
+	if err := os.Link(tmp, path); err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("'%s' already exists, use --force to overwrite it", path)
+		}
+		return err
+	}
+	return nil
+}
+
+// writeTemp writes content to a temporary file next to path and returns its
+// name.
+func writeTemp(path string, content []byte, mode os.FileMode, owner, group int64) (string, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return "", err
+	}
+
+	if err := fillTemp(tmp, content, mode, owner, group); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func fillTemp(tmp *os.File, content []byte, mode os.FileMode, owner, group int64) error {
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+
+	if owner >= 0 || group >= 0 {
+		if err := os.Chown(tmp.Name(), int(owner), int(group)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Export writes files into params.Dir if it is set, otherwise to outFile in
+// params.Format. name is the name of the exported entity.
+func Export(files []ExportFile, name, outFile string, params *ExportParams) error {
+	if *params.Dir != "" {
+		return ExportDir(files, name, *params.Dir, params)
+	}
+	return ExportFormat(files, outFile, params)
+}
+
+// ExportDir writes each file into dir, named by the template for its type or
+// its own name if there is none. Templates may use {name} for the name of the
+// exported entity. With params.Fullchain set, the certificate and its CA chain
+// are also written to a single file. Every file is written before any is moved
+// into place and, unless params.Force is set, none are left if one of them
+// already exists.
+func ExportDir(files []ExportFile, name, dir string, params *ExportParams) error {
+	templates, err := params.nameTemplates()
+	if err != nil {
+		return err
+	}
+
+	if *params.Fullchain {
+		var content []byte
+		for _, fileType := range []string{ExportFileCert, ExportFileCACert} {
+			for _, file := range filesOfType(files, fileType) {
+				content = append(content, bytes.TrimSpace(file.Content)...)
+				content = append(content, '\n')
+			}
+		}
+
+		if certs := filesOfType(files, ExportFileCert); len(certs) == 0 {
+			logger.Warn("no certificate to export, skipping fullchain")
+		} else {
+			fullchain := NewExportFile("fullchain.pem", ExportFileFullchain, certs[0].Mode, content)
+			fullchain.Owner, fullchain.Group = certs[0].Owner, certs[0].Group
+			files = append(files, fullchain)
+		}
+	}
+
+	paths := make([]string, len(files))
+	seen := make(map[string]bool)
+	for i, file := range files {
+		fileName := file.Name
+		if template, ok := templates[file.Type]; ok {
+			fileName = strings.Replace(template, "{name}", name, -1)
+		}
+
+		if fileName != filepath.Base(fileName) || fileName == "." || fileName == ".." {
+			return fmt.Errorf("invalid export file name: %s", fileName)
+		}
+		if seen[fileName] {
+			return fmt.Errorf("more than one file would be exported to %s", fileName)
+		}
+		seen[fileName] = true
+		paths[i] = filepath.Join(dir, fileName)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	var temps []string
+	defer func() {
+		for _, tmp := range temps {
+			os.Remove(tmp)
+		}
+	}()
+
+	for i, file := range files {
+		tmp, err := writeTemp(paths[i], file.Content, os.FileMode(file.Mode), file.Owner, file.Group)
+		if err != nil {
+			return err
+		}
+		temps = append(temps, tmp)
+	}
+
+	for i, path := range paths {
+		logger.Debugf("writing '%s'", path)
+		if err := placeFile(temps[i], path, *params.Force); err != nil {
+			// Without --force the files placed so far were created by us
+			if !*params.Force {
+				for _, placed := range paths[:i] {
+					os.Remove(placed)
+				}
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ExportFormat exports files in the format given by params. tgz archives every
+// file, the other formats are built from the certificate, CA and key files.
+func ExportFormat(files []ExportFile, outFile string, params *ExportParams) error {
+	var content []byte
+	var err error
+
+	switch *params.Format {
+	case ExportTGZ:
+		content, err = TarGZ(files)
+	case ExportPEMBundle:
+		content = PEMBundle(files)
+	case ExportDER:
+		content, err = DER(files)
+	case ExportP12:
+		var secret string
+		if secret, err = params.Passphrase.Read(); err == nil {
+			content, err = PKCS12(files, secret)
+		}
+	case ExportJKS:
+		var secret string
+		if secret, err = params.Passphrase.Read(); err == nil {
+			content, err = JKS(files, *params.Alias, secret)
+		}
+	default:
+		err = checkExportFormat(*params.Format)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return WriteExport(content, outFile, 0600, -1, -1, *params.Force)
+}
@@ -0,0 +1,275 @@
+// ThreatSpec package main
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"github.com/jawher/mow.cli"
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	oidKeyUsage    = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+)
+
+var extKeyUsageOIDs = map[x509.ExtKeyUsage]asn1.ObjectIdentifier{
+	x509.ExtKeyUsageAny:             {2, 5, 29, 37, 0},
+	x509.ExtKeyUsageServerAuth:      {1, 3, 6, 1, 5, 5, 7, 3, 1},
+	x509.ExtKeyUsageClientAuth:      {1, 3, 6, 1, 5, 5, 7, 3, 2},
+	x509.ExtKeyUsageCodeSigning:     {1, 3, 6, 1, 5, 5, 7, 3, 3},
+	x509.ExtKeyUsageEmailProtection: {1, 3, 6, 1, 5, 5, 7, 3, 4},
+	x509.ExtKeyUsageIPSECEndSystem:  {1, 3, 6, 1, 5, 5, 7, 3, 5},
+	x509.ExtKeyUsageIPSECTunnel:     {1, 3, 6, 1, 5, 5, 7, 3, 6},
+	x509.ExtKeyUsageIPSECUser:       {1, 3, 6, 1, 5, 5, 7, 3, 7},
+	x509.ExtKeyUsageTimeStamping:    {1, 3, 6, 1, 5, 5, 7, 3, 8},
+	x509.ExtKeyUsageOCSPSigning:     {1, 3, 6, 1, 5, 5, 7, 3, 9},
+}
+
+var dnsNamePattern = regexp.MustCompile(`^(\*\.)?([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.?$`)
+
+// Extensions are the subject alternative names and key usages of a
+// certificate or CSR.
+type Extensions struct {
+	DNSNames       []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+	EmailAddresses []string
+	KeyUsage       x509.KeyUsage
+	ExtKeyUsage    []x509.ExtKeyUsage
+}
+
+// ExtensionParams are the command line options for Extensions.
+type ExtensionParams struct {
+	DNSNames       *[]string
+	IPAddresses    *[]string
+	URIs           *[]string
+	EmailAddresses *[]string
+	KeyUsage       *[]string
+	ExtKeyUsage    *[]string
+}
+
+// NewExtensionParams declares the extension options of a command.
+func NewExtensionParams(cmd *cli.Cmd) *ExtensionParams {
+	params := new(ExtensionParams)
+	params.DNSNames = cmd.StringsOpt("dns", nil, "DNS name subject alternative name (repeatable)")
+	params.IPAddresses = cmd.StringsOpt("ip", nil, "IP address subject alternative name (repeatable)")
+	params.URIs = cmd.StringsOpt("uri", nil, "URI subject alternative name (repeatable)")
+	params.EmailAddresses = cmd.StringsOpt("email", nil, "email address subject alternative name (repeatable)")
+	params.KeyUsage = cmd.StringsOpt("key-usage", nil, "key usage, e.g. digitalSignature or keyEncipherment (repeatable)")
+	params.ExtKeyUsage = cmd.StringsOpt("ext-key-usage", nil, "extended key usage, e.g. serverAuth, clientAuth or codeSigning (repeatable)")
+	return params
+}
+
+// Given returns true if any extension option was used.
+func (p *ExtensionParams) Given() bool {
+	for _, values := range []*[]string{p.DNSNames, p.IPAddresses, p.URIs, p.EmailAddresses, p.KeyUsage, p.ExtKeyUsage} {
+		if len(*values) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse checks the extension options and applies them to ext, replacing only
+// the kinds of extension that were given.
+func (p *ExtensionParams) Parse(ext *Extensions) error {
+	if len(*p.DNSNames) > 0 {
+		ext.DNSNames = nil
+		for _, name := range *p.DNSNames {
+			if !dnsNamePattern.MatchString(name) || len(name) > 253 {
+				return fmt.Errorf("invalid DNS name: %s", name)
+			}
+			ext.DNSNames = append(ext.DNSNames, name)
+		}
+	}
+
+	if len(*p.IPAddresses) > 0 {
+		ext.IPAddresses = nil
+		for _, address := range *p.IPAddresses {
+			ip := net.ParseIP(address)
+			if ip == nil {
+				return fmt.Errorf("invalid IP address: %s", address)
+			}
+			ext.IPAddresses = append(ext.IPAddresses, ip)
+		}
+	}
+
+	if len(*p.URIs) > 0 {
+		ext.URIs = nil
+		for _, uri := range *p.URIs {
+			u, err := url.Parse(uri)
+			if err != nil || u.Scheme == "" || (u.Host == "" && u.Opaque == "") {
+				return fmt.Errorf("invalid URI, it must be absolute: %s", uri)
+			}
+			ext.URIs = append(ext.URIs, u)
+		}
+	}
+
+	if len(*p.EmailAddresses) > 0 {
+		ext.EmailAddresses = nil
+		for _, email := range *p.EmailAddresses {
+			address, err := mail.ParseAddress(email)
+			if err != nil || address.Address != email {
+				return fmt.Errorf("invalid email address: %s", email)
+			}
+			ext.EmailAddresses = append(ext.EmailAddresses, email)
+		}
+	}
+
+	if len(*p.KeyUsage) > 0 {
+		ext.KeyUsage = 0
+		for _, name := range *p.KeyUsage {
+			usage, err := parseKeyUsage(name)
+			if err != nil {
+				return err
+			}
+			ext.KeyUsage |= usage
+		}
+	}
+
+	if len(*p.ExtKeyUsage) > 0 {
+		ext.ExtKeyUsage = nil
+		for _, name := range *p.ExtKeyUsage {
+			usage, err := parseExtKeyUsage(name)
+			if err != nil {
+				return err
+			}
+			ext.ExtKeyUsage = append(ext.ExtKeyUsage, usage)
+		}
+	}
+
+	return nil
+}
+
+func parseKeyUsage(name string) (x509.KeyUsage, error) {
+	var names []string
+	for _, ku := range keyUsageNames {
+		if ku.name == name {
+			return ku.usage, nil
+		}
+		names = append(names, ku.name)
+	}
+	return 0, fmt.Errorf("invalid key usage '%s', use one of %s", name, strings.Join(names, ", "))
+}
+
+func parseExtKeyUsage(name string) (x509.ExtKeyUsage, error) {
+	for usage, usageName := range extKeyUsageNames {
+		if usageName == name {
+			return usage, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid extended key usage '%s', use e.g. serverAuth, clientAuth, codeSigning, emailProtection, timeStamping or OCSPSigning", name)
+}
+
+// Apply sets the extensions of a certificate template. Leaf certificates
+// without key usages get digitalSignature and keyEncipherment.
+func (ext *Extensions) Apply(template *x509.Certificate) {
+	template.DNSNames = ext.DNSNames
+	template.IPAddresses = ext.IPAddresses
+	template.URIs = ext.URIs
+	template.EmailAddresses = ext.EmailAddresses
+	template.ExtKeyUsage = ext.ExtKeyUsage
+
+	template.KeyUsage = ext.KeyUsage
+	if template.KeyUsage == 0 && !template.IsCA {
+		template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	}
+}
+
+// CertificateExtensions returns the extensions of a certificate.
+func CertificateExtensions(cert *x509.Certificate) *Extensions {
+	return &Extensions{
+		DNSNames:       cert.DNSNames,
+		IPAddresses:    cert.IPAddresses,
+		URIs:           cert.URIs,
+		EmailAddresses: cert.EmailAddresses,
+		KeyUsage:       cert.KeyUsage,
+		ExtKeyUsage:    cert.ExtKeyUsage,
+	}
+}
+
+// RequestedExtensions returns the extensions requested in a CSR.
+func RequestedExtensions(csr *x509.CertificateRequest) (*Extensions, error) {
+	ext := &Extensions{
+		DNSNames:       csr.DNSNames,
+		IPAddresses:    csr.IPAddresses,
+		URIs:           csr.URIs,
+		EmailAddresses: csr.EmailAddresses,
+	}
+
+	for _, e := range csr.Extensions {
+		switch {
+		case e.Id.Equal(oidKeyUsage):
+			var bits asn1.BitString
+			if _, err := asn1.Unmarshal(e.Value, &bits); err != nil {
+				return nil, fmt.Errorf("could not parse requested key usage: %s", err)
+			}
+			for i := 0; i < 9; i++ {
+				if bits.At(i) != 0 {
+					ext.KeyUsage |= 1 << uint(i)
+				}
+			}
+		case e.Id.Equal(oidExtKeyUsage):
+			var oids []asn1.ObjectIdentifier
+			if _, err := asn1.Unmarshal(e.Value, &oids); err != nil {
+				return nil, fmt.Errorf("could not parse requested extended key usage: %s", err)
+			}
+			for _, oid := range oids {
+				for usage, usageOID := range extKeyUsageOIDs {
+					if oid.Equal(usageOID) {
+						ext.ExtKeyUsage = append(ext.ExtKeyUsage, usage)
+					}
+				}
+			}
+		}
+	}
+	return ext, nil
+}
+
+// requestExtensions returns the key usage extensions to request in a CSR, as
+// the CSR template only has fields for subject alternative names.
+func (ext *Extensions) requestExtensions() ([]pkix.Extension, error) {
+	var extensions []pkix.Extension
+
+	if ext.KeyUsage != 0 {
+		var bits asn1.BitString
+		for i := 0; i < 9; i++ {
+			if ext.KeyUsage&(1<<uint(i)) != 0 {
+				if bits.Bytes == nil {
+					bits.Bytes = make([]byte, 2)
+				}
+				bits.Bytes[i/8] |= 0x80 >> uint(i%8)
+				bits.BitLength = i + 1
+			}
+		}
+		bits.Bytes = bits.Bytes[:(bits.BitLength+7)/8]
+
+		value, err := asn1.Marshal(bits)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidKeyUsage, Critical: true, Value: value})
+	}
+
+	if len(ext.ExtKeyUsage) > 0 {
+		var oids []asn1.ObjectIdentifier
+		for _, usage := range ext.ExtKeyUsage {
+			oids = append(oids, extKeyUsageOIDs[usage])
+		}
+
+		value, err := asn1.Marshal(oids)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidExtKeyUsage, Value: value})
+	}
+
+	return extensions, nil
+}
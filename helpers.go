@@ -2,14 +2,9 @@
 package main
 
 import (
-	"archive/tar"
-	"bytes"
-	"compress/gzip"
 	"encoding/hex"
-	"fmt"
 	"github.com/pki-io/core/crypto"
-	"io/ioutil"
-	"os"
+	"strings"
 	"time"
 )
 
@@ -18,55 +13,22 @@ func NewID() string {
 	return hex.EncodeToString(idBytes)
 }
 
-type ExportFile struct {
-	Name    string
-	Mode    int64
-	Owner   int64
-	Group   int64
-	Content []byte
-}
-
-func TarGZ(files []ExportFile) ([]byte, error) {
-	tarBuffer := new(bytes.Buffer)
-	tarWriter := tar.NewWriter(tarBuffer)
-
-	for _, file := range files {
-		header := &tar.Header{
-			Name:    file.Name,
-			Mode:    int64(file.Mode),
-			Size:    int64(len(file.Content)),
-			ModTime: time.Now(),
-		}
-		if err := tarWriter.WriteHeader(header); err != nil {
-			return nil, err
-		}
-		if _, err := tarWriter.Write(file.Content); err != nil {
-			return nil, err
-		}
+// formatTime formats a unix timestamp for display.
+func formatTime(t int64) string {
+	if t == 0 {
+		return ""
 	}
-
-	if err := tarWriter.Close(); err != nil {
-		return nil, err
-	}
-
-	zipBuffer := new(bytes.Buffer)
-	zipWriter := gzip.NewWriter(zipBuffer)
-	zipWriter.Write(tarBuffer.Bytes())
-	zipWriter.Close()
-
-	return zipBuffer.Bytes(), nil
+	return time.Unix(t, 0).UTC().Format(time.RFC3339)
 }
 
-func Export(files []ExportFile, outFile string) {
-	tarGz, err := TarGZ(files)
-	fmt.Println(err)
-
-	if outFile == "-" {
-		os.Stdout.Write(tarGz)
-	} else {
-		// Write  to file
-		err := ioutil.WriteFile(outFile, tarGz, 0600)
-		fmt.Println(err)
+// splitTags turns a comma separated list of tags into a slice, ignoring empty
+// entries.
+func splitTags(tags string) []string {
+	result := []string{}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			result = append(result, tag)
+		}
 	}
-
+	return result
 }
@@ -0,0 +1,247 @@
+// ThreatSpec package main
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Certificates that need more than the controllers can do, such as
+// intermediate CAs or subject alternative names, are issued here and then
+// imported into the org with the controllers' --cert and --key handling.
+
+// newPrivateKey generates a key of the given type, ec or rsa.
+func newPrivateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "ec":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "rsa":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return nil, fmt.Errorf("invalid key type: %s", keyType)
+	}
+}
+
+// keyTypeOf returns the key type name of a private key.
+func keyTypeOf(key crypto.Signer) string {
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		return "rsa"
+	}
+	return "ec"
+}
+
+// privateKeyPEM encodes an RSA key in PKCS#1 form or an EC key in SEC 1 form.
+func privateKeyPEM(key crypto.Signer) (string, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)})), nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return "", err
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})), nil
+	default:
+		return "", fmt.Errorf("unsupported private key type: %T", key)
+	}
+}
+
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// subjectName returns a subject with the given common name and the DN fields
+// of the command line.
+func subjectName(name, country, province, locality, org, orgUnit, street, postal string) pkix.Name {
+	subject := pkix.Name{CommonName: name}
+	for _, f := range []struct {
+		value string
+		field *[]string
+	}{
+		{country, &subject.Country},
+		{province, &subject.Province},
+		{locality, &subject.Locality},
+		{org, &subject.Organization},
+		{orgUnit, &subject.OrganizationalUnit},
+		{street, &subject.StreetAddress},
+		{postal, &subject.PostalCode},
+	} {
+		if f.value != "" {
+			*f.field = []string{f.value}
+		}
+	}
+	return subject
+}
+
+// validity returns the validity period of a new certificate, ending no later
+// than its issuer.
+func validity(days int, issuer *x509.Certificate) (time.Time, time.Time, error) {
+	if days <= 0 {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid expiry period: %d days", days)
+	}
+
+	notBefore := time.Now().Add(-5 * time.Minute).UTC()
+	notAfter := notBefore.AddDate(0, 0, days)
+	if issuer != nil && notAfter.After(issuer.NotAfter) {
+		logger.Warnf("limiting the expiry to that of issuer '%s' at %s", issuer.Subject.CommonName, issuer.NotAfter.UTC().Format(time.RFC3339))
+		notAfter = issuer.NotAfter
+	}
+	if !notAfter.After(time.Now()) {
+		return time.Time{}, time.Time{}, fmt.Errorf("issuer '%s' has expired", issuer.Subject.CommonName)
+	}
+	return notBefore, notAfter, nil
+}
+
+// signCertificate signs template for pub with the issuer's key, or self-signs
+// it if issuer is nil, and returns the PEM encoded certificate.
+func signCertificate(template *x509.Certificate, pub crypto.PublicKey, issuer *x509.Certificate, issuerKey crypto.Signer) (string, error) {
+	serial, err := newSerialNumber()
+	if err != nil {
+		return "", err
+	}
+	template.SerialNumber = serial
+
+	if issuer == nil {
+		issuer = template
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, pub, issuerKey)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), nil
+}
+
+// issueCertificate fills in the validity and serial number of template and
+// signs it with the PEM encoded issuer certificate and key, or self-signs it
+// if issuerCert is empty. A new key of keyType is generated unless keyPEM is
+// given. It returns the PEM encoded certificate and key.
+func issueCertificate(template *x509.Certificate, days int, keyType, keyPEM, issuerCert, issuerKeyPEM string) (string, string, error) {
+	var issuer *x509.Certificate
+	var issuerKey crypto.Signer
+	var err error
+
+	if issuerCert != "" {
+		if issuer, err = parseCertificate(issuerCert); err != nil {
+			return "", "", err
+		}
+		if !issuer.IsCA || issuer.KeyUsage&x509.KeyUsageCertSign == 0 {
+			return "", "", fmt.Errorf("'%s' isn't allowed to sign certificates", issuer.Subject.CommonName)
+		}
+		if issuerKeyPEM == "" {
+			return "", "", fmt.Errorf("there is no private key for '%s'", issuer.Subject.CommonName)
+		}
+		if issuerKey, err = parsePrivateKey(issuerKeyPEM); err != nil {
+			return "", "", err
+		}
+		if err := checkPathLen(template, issuer); err != nil {
+			return "", "", err
+		}
+	}
+
+	var key crypto.Signer
+	if keyPEM != "" {
+		key, err = parsePrivateKey(keyPEM)
+	} else {
+		key, err = newPrivateKey(keyType)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if issuer == nil {
+		issuerKey = key
+	}
+
+	if template.NotBefore, template.NotAfter, err = validity(days, issuer); err != nil {
+		return "", "", err
+	}
+
+	certPEM, err := signCertificate(template, key.Public(), issuer, issuerKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	if keyPEM == "" {
+		if keyPEM, err = privateKeyPEM(key); err != nil {
+			return "", "", err
+		}
+	}
+	return certPEM, keyPEM, nil
+}
+
+// checkPathLen makes sure a CA fits within the path length limit of its
+// issuer, limiting it to one less than the issuer if it has no limit itself.
+func checkPathLen(template, issuer *x509.Certificate) error {
+	if !template.IsCA || issuer.MaxPathLen < 0 {
+		return nil
+	}
+
+	if issuer.MaxPathLen == 0 {
+		return fmt.Errorf("'%s' has a maximum path length of 0 and can't issue CAs", issuer.Subject.CommonName)
+	}
+
+	limit := issuer.MaxPathLen - 1
+	if template.MaxPathLen < 0 {
+		template.MaxPathLen = limit
+		template.MaxPathLenZero = limit == 0
+	} else if template.MaxPathLen > limit {
+		return fmt.Errorf("the maximum path length can be at most %d below '%s'", limit, issuer.Subject.CommonName)
+	}
+	return nil
+}
+
+// newCSR returns a PEM encoded CSR for key requesting ext.
+func newCSR(subject pkix.Name, ext *Extensions, key crypto.Signer) (string, error) {
+	extensions, err := ext.requestExtensions()
+	if err != nil {
+		return "", err
+	}
+
+	template := &x509.CertificateRequest{
+		Subject:         subject,
+		DNSNames:        ext.DNSNames,
+		IPAddresses:     ext.IPAddresses,
+		URIs:            ext.URIs,
+		EmailAddresses:  ext.EmailAddresses,
+		ExtraExtensions: extensions,
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})), nil
+}
+
+// withTempFiles writes contents to files in a private temporary directory,
+// calls fn with their paths and removes them again.
+func withTempFiles(contents []string, fn func(paths []string) error) error {
+	dir, err := ioutil.TempDir("", "pkiio")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var paths []string
+	for i, content := range contents {
+		path := filepath.Join(dir, fmt.Sprintf("%d.pem", i))
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			return err
+		}
+		paths = append(paths, path)
+	}
+
+	return fn(paths)
+}
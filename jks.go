@@ -0,0 +1,166 @@
+// ThreatSpec package main
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// The Java keystore format, as written by the JDK's sun.security.provider
+// JavaKeyStore.
+const (
+	jksMagic          = 0xfeedfeed
+	jksVersion        = 2
+	jksPrivateKeyTag  = 1
+	jksTrustedCertTag = 2
+	jksCertType       = "X.509"
+	jksDigestWhitener = "Mighty Aphrodite"
+)
+
+// oidJKSKeyProtector identifies the JDK's proprietary key protection.
+var oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+// jksEntry is a keystore entry. Entries with a key are private key entries
+// whose chain starts with the key's certificate, others are trusted
+// certificate entries holding the first certificate of the chain.
+type jksEntry struct {
+	alias string
+	key   []byte
+	chain []*x509.Certificate
+}
+
+type jksEncoder struct {
+	buf bytes.Buffer
+	err error
+}
+
+func (e *jksEncoder) write(v interface{}) {
+	if e.err == nil {
+		e.err = binary.Write(&e.buf, binary.BigEndian, v)
+	}
+}
+
+func (e *jksEncoder) writeUTF(s string) {
+	if len(s) > 0xffff {
+		e.err = fmt.Errorf("'%s' is too long for a keystore", s)
+		return
+	}
+	e.write(uint16(len(s)))
+	e.write([]byte(s))
+}
+
+func (e *jksEncoder) writeBytes(b []byte) {
+	e.write(uint32(len(b)))
+	e.write(b)
+}
+
+func (e *jksEncoder) writeCert(cert *x509.Certificate) {
+	e.writeUTF(jksCertType)
+	e.writeBytes(cert.Raw)
+}
+
+// jksPassword returns a passphrase as the UTF-16 bytes that Java hashes.
+func jksPassword(passphrase string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(passphrase)) {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return b
+}
+
+// jksProtectKey encrypts a PKCS#8 key as the JDK's KeyProtector does, by
+// XORing it with a SHA-1 keystream of the password and a random salt, and
+// wraps it in an EncryptedPrivateKeyInfo.
+func jksProtectKey(key, password []byte) ([]byte, error) {
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	protected := append([]byte{}, salt...)
+	digest := salt
+	for i := 0; i < len(key); i += sha1.Size {
+		sum := sha1.Sum(append(append([]byte{}, password...), digest...))
+		digest = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(key); j++ {
+			protected = append(protected, key[i+j]^digest[j])
+		}
+	}
+
+	check := sha1.Sum(append(append([]byte{}, password...), key...))
+	protected = append(protected, check[:]...)
+
+	return asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		Data      []byte
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidJKSKeyProtector, Parameters: asn1.NullRawValue},
+		Data:      protected,
+	})
+}
+
+// encodeJKS returns a Java keystore of entries, with every key and the
+// keystore itself protected by passphrase. Java keystores ignore the case of
+// aliases, so they are lower cased and must be unique.
+func encodeJKS(entries []jksEntry, passphrase string) ([]byte, error) {
+	password := jksPassword(passphrase)
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	e := new(jksEncoder)
+	e.write(uint32(jksMagic))
+	e.write(uint32(jksVersion))
+	e.write(uint32(len(entries)))
+
+	aliases := make(map[string]bool)
+	for _, entry := range entries {
+		alias := strings.ToLower(entry.alias)
+		if aliases[alias] {
+			return nil, fmt.Errorf("duplicate keystore alias: %s", alias)
+		}
+		aliases[alias] = true
+
+		if len(entry.chain) == 0 {
+			return nil, fmt.Errorf("no certificate for keystore alias: %s", alias)
+		}
+
+		if entry.key == nil {
+			e.write(uint32(jksTrustedCertTag))
+			e.writeUTF(alias)
+			e.write(now)
+			e.writeCert(entry.chain[0])
+			continue
+		}
+
+		protected, err := jksProtectKey(entry.key, password)
+		if err != nil {
+			return nil, err
+		}
+
+		e.write(uint32(jksPrivateKeyTag))
+		e.writeUTF(alias)
+		e.write(now)
+		e.writeBytes(protected)
+		e.write(uint32(len(entry.chain)))
+		for _, cert := range entry.chain {
+			e.writeCert(cert)
+		}
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	digest := sha1.New()
+	digest.Write(password)
+	digest.Write([]byte(jksDigestWhitener))
+	digest.Write(e.buf.Bytes())
+	return append(e.buf.Bytes(), digest.Sum(nil)...), nil
+}
@@ -10,6 +10,14 @@ import (
 var version *bool
 var logLevel *string
 var logging *string
+var outputFormat *string
+
+// User config. The current profile supplies option defaults.
+var userConfig *Config
+var userConfigPath string
+var configErr error
+var profileName string
+var profile Profile
 
 // ThreatSpec TMv0.1 for main
 // Does cli handling for App:CLI
@@ -19,12 +27,59 @@ func main() {
 
 	cmd := cli.App("pki.io", "Scalable, open source X.509 certificate management")
 
+	// The profile is loaded before any options are declared as it sets their
+	// defaults. Errors are reported once logging is up.
+	userConfigPath, configErr = ConfigPath()
+	if configErr == nil {
+		userConfig, configErr = LoadConfig(userConfigPath)
+	}
+	if userConfig == nil {
+		userConfig = &Config{Profiles: make(map[string]Profile)}
+	}
+	profileOption, command := rootArgs(os.Args[1:])
+	configCommand := command == "config"
+	profileName = userConfig.ProfileName(profileOption)
+	profile = userConfig.Profiles[profileName]
+
 	// Global options
-	logLevel = cmd.StringOpt("l log-level", "info", "log level")
+	logLevel = cmd.StringOpt("l log-level", profile.String(ProfileLogLevel, "info"), "log level")
 	logging = cmd.StringOpt("logging", "", "alternative logging configuration")
+	outputFormat = cmd.StringOpt("o output", profile.String(ProfileOutput, OutputTable), "output format (table, json or yaml)")
+	// Already read by rootArgs, this declares it for parsing and help.
+	cmd.StringOpt("profile", profileName, "config profile")
 
 	cmd.Before = func() {
 		initLogging(*logLevel, *logging)
+		// The config commands are how a broken config gets fixed, so they
+		// only warn about it
+		if configErr != nil && configCommand {
+			logger.Warn(configErr)
+		} else if configErr != nil {
+			logger.Critical(configErr)
+			logger.Flush()
+			cli.Exit(1)
+		}
+		if err := checkOutputFormat(*outputFormat); err != nil {
+			logger.Critical(err)
+			logger.Flush()
+			cli.Exit(1)
+		}
+		// Carrying on without the profile would act on whatever org the
+		// environment points at, but config set --new is how it gets made
+		if profile == nil && profileName != "default" {
+			if configCommand {
+				logger.Warnf("profile '%s' isn't in '%s'", profileName, userConfigPath)
+			} else {
+				logger.Criticalf("profile '%s' isn't in '%s'", profileName, userConfigPath)
+				logger.Flush()
+				cli.Exit(1)
+			}
+		}
+		if err := profile.Apply(profileOption != ""); err != nil {
+			logger.Critical(err)
+			logger.Flush()
+			cli.Exit(1)
+		}
 	}
 	cmd.After = func() {
 		logger.Close()
@@ -37,8 +92,12 @@ func main() {
 	cmd.Command("node", "Manage node entities", nodeCmd)
 	cmd.Command("org", "Manage the organization", orgCmd)
 	cmd.Command("pairing-key", "Manage pairing keys", pairingKeyCmd)
+	cmd.Command("report", "Report on the organization", reportCmd)
+	cmd.Command("audit", "Inspect the organization audit log", auditCmd)
+	cmd.Command("container", "Encrypt, decrypt and inspect organization document containers", containerCmd)
+	cmd.Command("config", "Manage config profiles", configCmd)
+	cmd.Command("completion", "Print shell completion scripts", completionCmd)
 	cmd.Command("version", "Show version", versionCmd)
-	cmd.Command("hax0r", "Leet debug stuff", hax0rCmd)
 
 	cmd.Run(os.Args)
 }
@@ -0,0 +1,169 @@
+// ThreatSpec package main
+package main
+
+import (
+	"encoding/json"
+	"github.com/pki-io/controller"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// The first retry after a failed run waits retryDelay, or the interval if that
+// is shorter, doubling with each further failure up to the daemon's maximum
+// backoff.
+const retryDelay = 30 * time.Second
+
+// NodeDaemon runs a node's tasks every interval until it is stopped, and
+// records how it is getting on in a status file so that node health can be
+// watched.
+type NodeDaemon struct {
+	app        *AdminApp
+	params     *controller.NodeParams
+	interval   time.Duration
+	maxBackoff time.Duration
+	statusFile string
+	deployFile string
+
+	started     time.Time
+	lastRun     time.Time
+	lastSuccess time.Time
+	lastError   string
+	lastErrorAt time.Time
+	failures    int
+	certs       []*OutputDoc
+}
+
+func NewNodeDaemon(app *AdminApp, params *controller.NodeParams, interval, maxBackoff time.Duration, statusFile, deployFile string) *NodeDaemon {
+	daemon := new(NodeDaemon)
+	daemon.app = app
+	daemon.params = params
+	daemon.interval = interval
+	daemon.maxBackoff = maxBackoff
+	daemon.statusFile = statusFile
+	daemon.deployFile = deployFile
+	daemon.certs = []*OutputDoc{}
+	return daemon
+}
+
+// runOnce runs the node's tasks, deploys its certificates and refreshes the
+// list of certificates the node holds. The deploy config is read on every run
+// so that changes are picked up without a restart.
+func (d *NodeDaemon) runOnce() error {
+	certs, err := runNode(d.app, d.params, d.deployFile)
+	if err != nil {
+		return err
+	}
+
+	d.certs = []*OutputDoc{}
+	for _, cert := range certs {
+		doc := NewOutputDoc().Add("name", "Name", cert.Data.Body.Name)
+		if c, err := parseCertificate(cert.Data.Body.Certificate); err == nil {
+			doc.Add("serial", "Serial", c.SerialNumber.String()).
+				Add("not_after", "Not after", c.NotAfter.UTC().Format(time.RFC3339))
+		}
+		d.certs = append(d.certs, doc)
+	}
+
+	return nil
+}
+
+// nextDelay returns how long to wait before the next run, backing off
+// exponentially while runs keep failing.
+func (d *NodeDaemon) nextDelay() time.Duration {
+	if d.failures == 0 {
+		return d.interval
+	}
+
+	delay := retryDelay
+	if delay > d.interval {
+		delay = d.interval
+	}
+	for i := 1; i < d.failures && delay < d.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.maxBackoff {
+		delay = d.maxBackoff
+	}
+	return delay
+}
+
+func statusTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// writeStatus atomically replaces the status file.
+func (d *NodeDaemon) writeStatus(state string, nextRun time.Time) {
+	doc := NewOutputDoc().
+		Add("node", "Node", *d.params.Name).
+		Add("pid", "PID", os.Getpid()).
+		Add("state", "State", state).
+		Add("started", "Started", statusTime(d.started)).
+		Add("last_run", "Last run", statusTime(d.lastRun)).
+		Add("last_success", "Last success", statusTime(d.lastSuccess)).
+		Add("last_error", "Last error", d.lastError).
+		Add("last_error_at", "Last error at", statusTime(d.lastErrorAt)).
+		Add("consecutive_failures", "Consecutive failures", d.failures).
+		Add("next_run", "Next run", statusTime(nextRun)).
+		Add("certs", "Certificates", d.certs)
+
+	content, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		logger.Errorf("could not encode node status: %s", err)
+		return
+	}
+
+	if err := WriteExport(append(content, '\n'), d.statusFile, 0644, -1, -1, true); err != nil {
+		logger.Errorf("could not write status file '%s': %s", d.statusFile, err)
+	}
+}
+
+// Run loops until SIGTERM or SIGINT. SIGHUP runs the tasks straight away. A
+// signal received during a run is handled once the run has finished.
+func (d *NodeDaemon) Run() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	d.started = time.Now()
+	logger.Infof("starting node daemon for '%s' every %s", *d.params.Name, d.interval)
+
+	for {
+		d.lastRun = time.Now()
+		d.writeStatus("running", time.Time{})
+
+		if err := d.runOnce(); err != nil {
+			d.failures++
+			d.lastError = err.Error()
+			d.lastErrorAt = time.Now()
+			logger.Errorf("node run failed (%d in a row): %s", d.failures, err)
+		} else {
+			d.failures = 0
+			d.lastSuccess = time.Now()
+			logger.Info("node run succeeded")
+		}
+
+		delay := d.nextDelay()
+		d.writeStatus("waiting", time.Now().Add(delay))
+		logger.Debugf("next node run in %s", delay)
+		logger.Flush()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case sig := <-signals:
+			timer.Stop()
+			if sig == syscall.SIGHUP {
+				logger.Info("received SIGHUP, running now")
+				continue
+			}
+			logger.Infof("received %s, stopping", sig)
+			d.writeStatus("stopped", time.Time{})
+			return
+		}
+	}
+}
@@ -0,0 +1,410 @@
+// ThreatSpec package main
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"github.com/pki-io/controller"
+	"golang.org/x/crypto/ocsp"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ocspStatus struct {
+	revoked   bool
+	revokedAt time.Time
+	reason    int
+}
+
+type ocspCachedResponse struct {
+	der     []byte
+	expires time.Time
+}
+
+// OCSPResponder answers RFC 6960 OCSP requests for certificates issued by a
+// single org CA. Certificate status is loaded from the org and reloaded, along
+// with the response cache, once the cache TTL has passed.
+type OCSPResponder struct {
+	app    *AdminApp
+	caName string
+	ttl    time.Duration
+	prefix string
+
+	// Optional delegated OCSP signing certificate and key
+	signerCert *x509.Certificate
+	signerKey  crypto.Signer
+
+	mu       sync.Mutex
+	loaded   time.Time
+	issuer   *x509.Certificate
+	signer   crypto.Signer
+	statuses map[string]ocspStatus
+	cache    map[string]ocspCachedResponse
+}
+
+// NewOCSPResponder returns a responder that answers GET requests below the
+// URL path prefix.
+func NewOCSPResponder(app *AdminApp, caName string, ttl time.Duration, prefix string) *OCSPResponder {
+	responder := new(OCSPResponder)
+	responder.app = app
+	responder.caName = caName
+	responder.ttl = ttl
+	responder.prefix = "/" + strings.Trim(prefix, "/")
+	if responder.prefix != "/" {
+		responder.prefix += "/"
+	}
+	return responder
+}
+
+// UseSigner makes the responder sign responses with a delegated OCSP signing
+// certificate instead of the CA key.
+func (r *OCSPResponder) UseSigner(certFile, keyFile string) error {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+
+	cert, err := parseCertificate(string(certPEM))
+	if err != nil {
+		return err
+	}
+
+	key, err := parsePrivateKey(string(keyPEM))
+	if err != nil {
+		return err
+	}
+
+	if !publicKeysEqual(cert.PublicKey, key.Public()) {
+		return fmt.Errorf("OCSP signing key doesn't match the certificate in %s", certFile)
+	}
+
+	r.signerCert = cert
+	r.signerKey = key
+	return nil
+}
+
+// load reads the CA and the status of every certificate it issued from the
+// org. It must be called with the lock held.
+func (r *OCSPResponder) load() error {
+	logger.Debugf("loading OCSP data for CA '%s'", r.caName)
+
+	caCont, err := controller.NewCA(r.app.env)
+	if err != nil {
+		return err
+	}
+
+	private := true
+	caParams := controller.NewCAParams()
+	caParams.Name = &r.caName
+	caParams.Private = &private
+
+	ca, err := caCont.Show(caParams)
+	if err != nil {
+		return err
+	}
+
+	issuer, err := parseCertificate(ca.Data.Body.Certificate)
+	if err != nil {
+		return err
+	}
+
+	signer := r.signerKey
+	if signer == nil {
+		if signer, err = parsePrivateKey(ca.Data.Body.PrivateKey); err != nil {
+			return fmt.Errorf("could not load CA private key: %s", err)
+		}
+	} else if err := checkOCSPSigner(r.signerCert, issuer); err != nil {
+		return err
+	}
+
+	certCont, err := controller.NewCertificate(r.app.env)
+	if err != nil {
+		return err
+	}
+
+	certs, err := certCont.List(controller.NewCertificateParams())
+	if err != nil {
+		return err
+	}
+
+	revocations := new(Revocations)
+	if _, err := r.app.Store().Load(revocationsDoc, revocations); err != nil {
+		return err
+	}
+
+	// Current certificates are good unless revoked. Revocations are by
+	// serial number, so certificates that have since been renewed or
+	// deleted are still reported as revoked.
+	statuses := make(map[string]ocspStatus)
+	for _, c := range certs {
+		cert, err := parseCertificate(c.Data.Body.Certificate)
+		if err != nil {
+			logger.Warnf("skipping certificate '%s': %s", c.Name(), err)
+			continue
+		}
+
+		if findIssuer(cert, []*x509.Certificate{issuer}) < 0 {
+			continue
+		}
+		statuses[cert.SerialNumber.String()] = ocspStatus{}
+	}
+
+	for _, revocation := range revocations.ForCA(ca.Id()) {
+		statuses[revocation.Serial] = ocspStatus{
+			revoked:   true,
+			revokedAt: time.Unix(revocation.RevokedAt, 0),
+			reason:    revocationReasonCode(revocation.Reason),
+		}
+	}
+
+	logger.Infof("loaded status of %d certificates issued by '%s'", len(statuses), r.caName)
+
+	r.issuer = issuer
+	r.signer = signer
+	r.statuses = statuses
+	r.cache = make(map[string]ocspCachedResponse)
+	r.loaded = time.Now()
+	return nil
+}
+
+// CheckSigner makes sure the delegated signing certificate, if there is one,
+// can sign responses for the CA.
+func (r *OCSPResponder) CheckSigner() error {
+	if r.signerCert == nil {
+		return nil
+	}
+
+	cont, err := controller.NewCA(r.app.env)
+	if err != nil {
+		return err
+	}
+
+	private := false
+	params := controller.NewCAParams()
+	params.Name = &r.caName
+	params.Private = &private
+
+	ca, err := cont.Show(params)
+	if err != nil {
+		return err
+	}
+	if ca == nil {
+		return fmt.Errorf("CA '%s' not found", r.caName)
+	}
+
+	issuer, err := parseCertificate(ca.Data.Body.Certificate)
+	if err != nil {
+		return err
+	}
+	return checkOCSPSigner(r.signerCert, issuer)
+}
+
+// checkOCSPSigner makes sure a delegated signing certificate is one that
+// clients will accept, as described in RFC 6960 section 4.2.2.2.
+func checkOCSPSigner(signer, issuer *x509.Certificate) error {
+	if !bytes.Equal(signer.RawIssuer, issuer.RawSubject) || signer.CheckSignatureFrom(issuer) != nil {
+		return fmt.Errorf("OCSP signing certificate '%s' wasn't issued by CA '%s'", signer.Subject.CommonName, issuer.Subject.CommonName)
+	}
+
+	ocspSigning := false
+	for _, eku := range signer.ExtKeyUsage {
+		if eku == x509.ExtKeyUsageOCSPSigning {
+			ocspSigning = true
+		}
+	}
+	if !ocspSigning {
+		return fmt.Errorf("OCSP signing certificate '%s' doesn't have the OCSPSigning extended key usage", signer.Subject.CommonName)
+	}
+
+	if now := time.Now(); now.Before(signer.NotBefore) || now.After(signer.NotAfter) {
+		return fmt.Errorf("OCSP signing certificate '%s' isn't valid at %s", signer.Subject.CommonName, now.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// publicKeysEqual returns true if two public keys are the same.
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface {
+		Equal(crypto.PublicKey) bool
+	})
+	return ok && key.Equal(b)
+}
+
+// Load reads the OCSP data from the org.
+func (r *OCSPResponder) Load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.load()
+}
+
+func (r *OCSPResponder) issuerMatches(req *ocsp.Request) bool {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(r.issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return false
+	}
+
+	if !req.HashAlgorithm.Available() {
+		return false
+	}
+
+	h := req.HashAlgorithm.New()
+	h.Write(r.issuer.RawSubject)
+	nameHash := h.Sum(nil)
+
+	h.Reset()
+	h.Write(spki.PublicKey.RightAlign())
+	keyHash := h.Sum(nil)
+
+	return bytes.Equal(nameHash, req.IssuerNameHash) && bytes.Equal(keyHash, req.IssuerKeyHash)
+}
+
+// Respond returns a DER encoded OCSP response for a DER encoded request, and
+// when it stops being valid. Error responses have a zero expiry.
+func (r *OCSPResponder) Respond(reqDER []byte) ([]byte, time.Time) {
+	req, err := ocsp.ParseRequest(reqDER)
+	if err != nil {
+		logger.Warnf("malformed OCSP request: %s", err)
+		return ocsp.MalformedRequestErrorResponse, time.Time{}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.loaded) > r.ttl {
+		if err := r.load(); err != nil {
+			// Keep answering from the previous data rather than failing
+			logger.Errorf("could not reload OCSP data: %s", err)
+		}
+	}
+
+	if r.issuer == nil {
+		return ocsp.InternalErrorErrorResponse, time.Time{}
+	}
+
+	if !r.issuerMatches(req) {
+		logger.Infof("OCSP request for unknown issuer, serial %s", req.SerialNumber)
+		return ocsp.UnauthorizedErrorResponse, time.Time{}
+	}
+
+	serial := req.SerialNumber.String()
+	if cached, ok := r.cache[serial]; ok && now.Before(cached.expires) {
+		logger.Debugf("cached OCSP response for serial %s", serial)
+		return cached.der, cached.expires
+	}
+
+	template := ocsp.Response{
+		SerialNumber: new(big.Int).Set(req.SerialNumber),
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(r.ttl),
+	}
+
+	status, ok := r.statuses[serial]
+	switch {
+	case !ok:
+		template.Status = ocsp.Unknown
+	case status.revoked:
+		template.Status = ocsp.Revoked
+		template.RevokedAt = status.revokedAt
+		template.RevocationReason = status.reason
+	default:
+		template.Status = ocsp.Good
+	}
+
+	responderCert := r.issuer
+	if r.signerCert != nil {
+		responderCert = r.signerCert
+		template.Certificate = r.signerCert
+	}
+
+	der, err := ocsp.CreateResponse(r.issuer, responderCert, template, r.signer)
+	if err != nil {
+		logger.Errorf("could not create OCSP response: %s", err)
+		return ocsp.InternalErrorErrorResponse, time.Time{}
+	}
+
+	logger.Infof("OCSP response for serial %s: %s", serial, ocspStatusName(template.Status))
+	r.cache[serial] = ocspCachedResponse{der: der, expires: template.NextUpdate}
+	return der, template.NextUpdate
+}
+
+func ocspStatusName(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
+
+// getRequest decodes the base64 request in the path of a GET request, which
+// is URL escaped after the responder's prefix. The escaped path is used since
+// the base64 may hold escaped slashes.
+func (r *OCSPResponder) getRequest(u *url.URL) ([]byte, error) {
+	path := u.EscapedPath()
+	if !strings.HasPrefix(path, r.prefix) {
+		return nil, fmt.Errorf("path '%s' isn't below '%s'", path, r.prefix)
+	}
+
+	encoded, err := url.PathUnescape(strings.TrimPrefix(path, r.prefix))
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+// ServeHTTP handles OCSP over HTTP as described in RFC 6960 appendix A, with
+// requests either POSTed or base64 encoded in the GET path.
+func (r *OCSPResponder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var reqDER []byte
+	var err error
+
+	switch req.Method {
+	case "POST":
+		reqDER, err = ioutil.ReadAll(http.MaxBytesReader(w, req.Body, 10000))
+	case "GET":
+		reqDER, err = r.getRequest(req.URL)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		logger.Warnf("bad OCSP request: %s", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	resp, expires := r.Respond(reqDER)
+	w.Header().Set("Content-Type", "application/ocsp-response")
+
+	// Caches may keep a response until its next update, however long ago
+	// it was made
+	if maxAge := int(time.Until(expires).Seconds()); maxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public, no-transform, must-revalidate", maxAge))
+		w.Header().Set("Expires", expires.UTC().Format(http.TimeFormat))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Write(resp)
+}
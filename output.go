@@ -0,0 +1,281 @@
+// ThreatSpec package main
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	OutputTable string = "table"
+	OutputJSON  string = "json"
+	OutputYAML  string = "yaml"
+)
+
+func checkOutputFormat(format string) error {
+	switch format {
+	case OutputTable, OutputJSON, OutputYAML:
+		return nil
+	default:
+		return fmt.Errorf("invalid output format: %s", format)
+	}
+}
+
+type outputField struct {
+	key   string
+	title string
+	value interface{}
+	block bool
+}
+
+// OutputDoc is an ordered set of fields describing a single entity. Fields are
+// rendered as table rows, or as keys of a JSON/YAML document, in the order
+// they were added.
+type OutputDoc struct {
+	fields []outputField
+}
+
+func NewOutputDoc() *OutputDoc {
+	return new(OutputDoc)
+}
+
+// Add adds a field that is shown in tables. The value may be a string, int,
+// bool, []string, *OutputDoc or []*OutputDoc.
+func (doc *OutputDoc) Add(key, title string, value interface{}) *OutputDoc {
+	doc.fields = append(doc.fields, outputField{key: key, title: title, value: value})
+	return doc
+}
+
+// AddBlock adds a multi-line field, such as a PEM encoded certificate, that is
+// printed after the table rather than in it.
+func (doc *OutputDoc) AddBlock(key, title, value string) *OutputDoc {
+	doc.fields = append(doc.fields, outputField{key: key, title: title, value: value, block: true})
+	return doc
+}
+
+func (doc *OutputDoc) field(title string) (outputField, bool) {
+	for _, f := range doc.fields {
+		if f.title == title {
+			return f, true
+		}
+	}
+	return outputField{}, false
+}
+
+func (doc *OutputDoc) MarshalJSON() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString("{")
+	for i, f := range doc.fields {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		key, err := json.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteString(":")
+		buf.Write(value)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+func tableValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, ",")
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case *OutputDoc:
+		if f, ok := v.field("Name"); ok {
+			return tableValue(f.value)
+		}
+		return ""
+	case []*OutputDoc:
+		var names []string
+		for _, d := range v {
+			if name := tableValue(d); name != "" {
+				names = append(names, name)
+			}
+		}
+		return strings.Join(names, ",")
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func yamlScalar(s string) string {
+	// JSON strings are valid YAML double quoted scalars
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func writeYAML(w io.Writer, value interface{}, indent string) {
+	switch v := value.(type) {
+	case *OutputDoc:
+		if len(v.fields) == 0 {
+			fmt.Fprintf(w, " {}\n")
+			return
+		}
+		fmt.Fprintf(w, "\n")
+		writeYAMLDoc(w, v, indent, indent)
+	case []*OutputDoc:
+		if len(v) == 0 {
+			fmt.Fprintf(w, " []\n")
+			return
+		}
+		fmt.Fprintf(w, "\n")
+		writeYAMLDocs(w, v, indent)
+	case []string:
+		if len(v) == 0 {
+			fmt.Fprintf(w, " []\n")
+			return
+		}
+		fmt.Fprintf(w, "\n")
+		for _, s := range v {
+			fmt.Fprintf(w, "%s- %s\n", indent, yamlScalar(s))
+		}
+	case string:
+		fmt.Fprintf(w, " %s\n", yamlScalar(v))
+	case nil:
+		fmt.Fprintf(w, " null\n")
+	default:
+		fmt.Fprintf(w, " %v\n", v)
+	}
+}
+
+func writeYAMLDoc(w io.Writer, doc *OutputDoc, first, indent string) {
+	for i, f := range doc.fields {
+		prefix := indent
+		if i == 0 {
+			prefix = first
+		}
+		fmt.Fprintf(w, "%s%s:", prefix, f.key)
+		writeYAML(w, f.value, indent+"  ")
+	}
+}
+
+func writeYAMLDocs(w io.Writer, docs []*OutputDoc, indent string) {
+	for _, doc := range docs {
+		if len(doc.fields) == 0 {
+			fmt.Fprintf(w, "%s- {}\n", indent)
+			continue
+		}
+		writeYAMLDoc(w, doc, indent+"- ", indent+"  ")
+	}
+}
+
+// RenderItem writes a single document to stdout in the selected output format.
+func (app *AdminApp) RenderItem(doc *OutputDoc) {
+	logger.Debug("rendering output")
+	logger.Flush()
+
+	switch app.output {
+	case OutputJSON:
+		app.renderJSON(doc)
+	case OutputYAML:
+		writeYAMLDoc(os.Stdout, doc, "", "")
+	default:
+		table := app.NewTable()
+		var blocks []outputField
+		for _, f := range doc.fields {
+			if f.block {
+				blocks = append(blocks, f)
+				continue
+			}
+			if sub, ok := f.value.(*OutputDoc); ok {
+				for _, sf := range sub.fields {
+					table.Append([]string{sf.title, tableValue(sf.value)})
+				}
+				continue
+			}
+			table.Append([]string{f.title, tableValue(f.value)})
+		}
+		table.Render()
+
+		for i, f := range blocks {
+			if i == 0 {
+				fmt.Println("")
+			}
+			fmt.Printf("%s:\n%s\n", f.title, f.value)
+		}
+	}
+}
+
+// RenderResult writes the result of creating an entity. JSON and YAML output
+// describe the entity in full, while table output only shows the summary.
+func (app *AdminApp) RenderResult(doc, summary *OutputDoc) {
+	if app.output == OutputTable {
+		app.RenderItem(summary)
+	} else {
+		app.RenderItem(doc)
+	}
+}
+
+// RenderList writes a list of documents to stdout in the selected output
+// format. In table format only the fields with the given titles are shown as
+// columns.
+func (app *AdminApp) RenderList(docs []*OutputDoc, columns ...string) {
+	logger.Debug("rendering output")
+	logger.Flush()
+
+	if docs == nil {
+		docs = []*OutputDoc{}
+	}
+
+	switch app.output {
+	case OutputJSON:
+		app.renderJSON(docs)
+	case OutputYAML:
+		if len(docs) == 0 {
+			fmt.Println("[]")
+			return
+		}
+		writeYAMLDocs(os.Stdout, docs, "")
+	default:
+		table := app.NewTable()
+		table.SetHeader(columns)
+		for _, doc := range docs {
+			var row []string
+			for _, column := range columns {
+				f, _ := doc.field(column)
+				row = append(row, tableValue(f.value))
+			}
+			table.Append(row)
+		}
+		table.Render()
+	}
+}
+
+func (app *AdminApp) renderJSON(value interface{}) {
+	out, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		app.Fatal(err)
+	}
+	fmt.Println(string(out))
+}
+
+func entityOutput(id, name, keyType, signingKey, encryptionKey string) *OutputDoc {
+	return NewOutputDoc().
+		Add("id", "ID", id).
+		Add("name", "Name", name).
+		Add("key_type", "Key type", keyType).
+		AddBlock("public_signing_key", "Public signing key", signingKey).
+		AddBlock("public_encryption_key", "Public encryption key", encryptionKey)
+}
@@ -0,0 +1,175 @@
+// ThreatSpec package main
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// revocationsDoc is the org store document holding every revocation.
+const revocationsDoc = "revocations"
+
+var oidCRLReason = asn1.ObjectIdentifier{2, 5, 29, 21}
+
+// Revocation records a revoked certificate and the org CA that issued it.
+type Revocation struct {
+	CertId    string `json:"cert_id"`
+	Name      string `json:"name"`
+	Serial    string `json:"serial"`
+	CAId      string `json:"ca_id"`
+	RevokedAt int64  `json:"revoked_at"`
+	Reason    string `json:"reason"`
+}
+
+// Revocations are the org's revoked certificates, along with the number of
+// the last CRL generated by each CA.
+type Revocations struct {
+	Certs      []*Revocation    `json:"certs"`
+	CRLNumbers map[string]int64 `json:"crl_numbers"`
+}
+
+// checkRevocationReason returns an error unless reason is one of the RFC 5280
+// reason names.
+func checkRevocationReason(reason string) error {
+	if _, ok := revocationReasons[reason]; ok {
+		return nil
+	}
+
+	var names []string
+	for name := range revocationReasons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("invalid revocation reason '%s', use one of %s", reason, strings.Join(names, ", "))
+}
+
+// loadRevocations reads the org's revocations, exiting on failure.
+func loadRevocations(app *AdminApp) *Revocations {
+	revocations := new(Revocations)
+	if _, err := app.Store().Load(revocationsDoc, revocations); err != nil {
+		app.Fatal(err)
+	}
+	return revocations
+}
+
+// Find returns the revocation of a certificate, or nil if it isn't revoked.
+// A renewed certificate keeps its id but gets a new serial number, so both
+// have to match.
+func (r *Revocations) Find(certId, serial string) *Revocation {
+	for _, revocation := range r.Certs {
+		if revocation.CertId == certId && revocation.Serial == serial {
+			return revocation
+		}
+	}
+	return nil
+}
+
+// FindSerial returns the revocation of the certificate with a serial number
+// issued by a CA, or nil if it isn't revoked. It still finds certificates
+// that have since been renewed or deleted.
+func (r *Revocations) FindSerial(caId, serial string) *Revocation {
+	for _, revocation := range r.Certs {
+		if revocation.CAId == caId && revocation.Serial == serial {
+			return revocation
+		}
+	}
+	return nil
+}
+
+// ForCA returns the revocations of certificates issued by a CA.
+func (r *Revocations) ForCA(caId string) []*Revocation {
+	var revocations []*Revocation
+	for _, revocation := range r.Certs {
+		if revocation.CAId == caId {
+			revocations = append(revocations, revocation)
+		}
+	}
+	return revocations
+}
+
+// NextCRLNumber increments and returns the CRL number of a CA.
+func (r *Revocations) NextCRLNumber(caId string) int64 {
+	if r.CRLNumbers == nil {
+		r.CRLNumbers = make(map[string]int64)
+	}
+	r.CRLNumbers[caId]++
+	return r.CRLNumbers[caId]
+}
+
+// signCRL returns a PEM encoded CRL of revocations signed by the CA
+// certificate and key, valid for nextUpdate.
+func signCRL(caCert, caKey string, revocations []*Revocation, number int64, nextUpdate time.Duration) (string, error) {
+	issuer, err := parseCertificate(caCert)
+	if err != nil {
+		return "", err
+	}
+	if issuer.KeyUsage != 0 && issuer.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		return "", fmt.Errorf("'%s' isn't allowed to sign CRLs", issuer.Subject.CommonName)
+	}
+
+	key, err := parsePrivateKey(caKey)
+	if err != nil {
+		return "", fmt.Errorf("could not load CA private key: %s", err)
+	}
+
+	// The CRL's authority key identifier is taken from the CA, so derive
+	// one for CAs that lack it, as RFC 5280 method 1 does
+	if len(issuer.SubjectKeyId) == 0 {
+		var spki struct {
+			Algorithm pkix.AlgorithmIdentifier
+			PublicKey asn1.BitString
+		}
+		if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+			return "", err
+		}
+		sum := sha1.Sum(spki.PublicKey.Bytes)
+		issuer.SubjectKeyId = sum[:]
+	}
+
+	var revoked []pkix.RevokedCertificate
+	for _, revocation := range revocations {
+		serial, ok := new(big.Int).SetString(revocation.Serial, 10)
+		if !ok {
+			return "", fmt.Errorf("invalid serial number of '%s': %s", revocation.Name, revocation.Serial)
+		}
+
+		entry := pkix.RevokedCertificate{
+			SerialNumber:   serial,
+			RevocationTime: time.Unix(revocation.RevokedAt, 0).UTC(),
+		}
+
+		// RFC 5280 says to leave out the reason code rather than use
+		// unspecified
+		if code := revocationReasons[revocation.Reason]; code != 0 {
+			value, err := asn1.Marshal(asn1.Enumerated(code))
+			if err != nil {
+				return "", err
+			}
+			entry.Extensions = []pkix.Extension{{Id: oidCRLReason, Value: value}}
+		}
+		revoked = append(revoked, entry)
+	}
+
+	now := time.Now().UTC()
+	template := &x509.RevocationList{
+		RevokedCertificates: revoked,
+		Number:              big.NewInt(number),
+		ThisUpdate:          now,
+		NextUpdate:          now.Add(nextUpdate),
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, template, issuer, key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})), nil
+}
@@ -0,0 +1,278 @@
+// ThreatSpec package main
+package main
+
+import (
+	"fmt"
+	"github.com/pki-io/controller"
+	"strings"
+)
+
+const (
+	RoleOwner        string = "owner"
+	RoleCAOperator   string = "ca-operator"
+	RoleIssuer       string = "issuer"
+	RoleAuditor      string = "auditor"
+	RoleNodeOperator string = "node-operator"
+)
+
+const (
+	PermRead        string = "read"
+	PermReadPrivate string = "read-private"
+	PermManageCA    string = "manage-ca"
+	PermIssue       string = "issue"
+	PermManageCert  string = "manage-cert"
+	PermManageNode  string = "manage-node"
+	PermManageAdmin string = "manage-admin"
+	PermManageOrg   string = "manage-org"
+)
+
+var rolePermissions = map[string][]string{
+	RoleOwner:        {PermRead, PermReadPrivate, PermManageCA, PermIssue, PermManageCert, PermManageNode, PermManageAdmin, PermManageOrg},
+	RoleCAOperator:   {PermRead, PermReadPrivate, PermManageCA, PermIssue, PermManageCert},
+	RoleIssuer:       {PermRead, PermReadPrivate, PermIssue, PermManageCert},
+	RoleAuditor:      {PermRead},
+	RoleNodeOperator: {PermRead, PermManageNode},
+}
+
+func checkRole(role string) error {
+	if _, ok := rolePermissions[role]; !ok {
+		return fmt.Errorf("invalid role: %s", role)
+	}
+	return nil
+}
+
+// rolesDoc is the org store document holding the admins' roles. The
+// controllers know nothing of roles, so they are only enforced by this CLI,
+// and an admin holding the org's keys could change them with another client.
+const rolesDoc = "roles"
+
+// adminsDoc is the home directory document that records, for each org, the
+// name of the admin the home directory belongs to.
+const adminsDoc = "admins"
+
+// Role is an admin's role, optionally scoped to CAs, certificates and CSRs
+// with any of the given tags.
+type Role struct {
+	Name  string   `json:"name"`
+	Scope []string `json:"scope,omitempty"`
+}
+
+// Roles maps admin names to roles.
+type Roles map[string]*Role
+
+func (r *Role) String() string {
+	if len(r.Scope) == 0 {
+		return r.Name
+	}
+	return fmt.Sprintf("%s (%s)", r.Name, strings.Join(r.Scope, ", "))
+}
+
+func (r *Role) Allows(perm string) bool {
+	for _, p := range rolePermissions[r.Name] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// InScope returns true if the role is unscoped or shares a tag with tags.
+func (r *Role) InScope(tags []string) bool {
+	if len(r.Scope) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		for _, scope := range r.Scope {
+			if tag == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadRole reads the role of an admin, or of the current admin if name is
+// empty. Admins without a role, such as those who joined with an invite made
+// before roles existed, get the least privileged role. Orgs made before roles
+// existed have no owner though, so until one is recorded every admin without
+// a role is an owner, or nobody could ever grant one.
+func loadRole(app *AdminApp, name string) (*Role, error) {
+	roles := make(Roles)
+	if _, err := app.Store().Load(rolesDoc, &roles); err != nil {
+		return nil, err
+	}
+	fallback := &Role{Name: RoleAuditor}
+	if !roles.hasOwner() {
+		fallback = &Role{Name: RoleOwner}
+	}
+
+	if name == "" {
+		current, err := currentAdmin(app)
+		if err != nil {
+			return nil, err
+		}
+		if current == "" {
+			logger.Warnf("this home directory has no admin name for the org, acting as %s", fallback.Name)
+			return fallback, nil
+		}
+		name = current
+	}
+
+	if role, ok := roles[name]; ok {
+		return role, nil
+	}
+	return fallback, nil
+}
+
+// hasOwner returns true if any of the roles is an unscoped owner.
+func (r Roles) hasOwner() bool {
+	for _, role := range r {
+		if role.Name == RoleOwner && len(role.Scope) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// setRole records an admin's role, or removes it if role is nil. It refuses
+// to leave the org without an unscoped owner. In an org without one, the
+// admins loadRole treats as owners are first recorded as such.
+func setRole(app *AdminApp, name string, role *Role) error {
+	cont, err := controller.NewAdmin(app.env)
+	if err != nil {
+		return err
+	}
+
+	roles := make(Roles)
+	return app.Store().Update(rolesDoc, &roles, func() error {
+		if !roles.hasOwner() {
+			admins, err := adminNames(cont)
+			if err != nil {
+				return err
+			}
+			for admin := range admins {
+				if _, ok := roles[admin]; !ok {
+					roles[admin] = &Role{Name: RoleOwner}
+				}
+			}
+		}
+
+		hadOwner := roles.hasOwner()
+		if role == nil {
+			delete(roles, name)
+		} else {
+			roles[name] = role
+		}
+
+		if hadOwner && !roles.hasOwner() {
+			return fmt.Errorf("the org must keep an unscoped owner")
+		}
+		return nil
+	})
+}
+
+// currentAdmin returns the name of the admin using the org, or "" if the home
+// directory has no record of it.
+func currentAdmin(app *AdminApp) (string, error) {
+	admins := make(map[string]string)
+	if err := loadHomeDoc(adminsDoc, &admins); err != nil {
+		return "", err
+	}
+	return admins[app.Store().org.Id()], nil
+}
+
+// setCurrentAdmin records the name of the admin using the org, once the home
+// directory holds the admin's keys for it.
+func setCurrentAdmin(app *AdminApp, name string) error {
+	admins := make(map[string]string)
+	if err := loadHomeDoc(adminsDoc, &admins); err != nil {
+		return err
+	}
+	admins[app.Store().org.Id()] = name
+	return saveHomeDoc(adminsDoc, admins)
+}
+
+// entityTags returns the tags given for a new entity, where the default of
+// "NAME" stands for the entity's name.
+func entityTags(tags, name string) []string {
+	return splitTags(strings.Replace(tags, "NAME", name, -1))
+}
+
+// tagsOf returns a lookup of fixed tags for Authorize.
+func tagsOf(tags []string) func() ([]string, error) {
+	return func() ([]string, error) {
+		return tags, nil
+	}
+}
+
+// caTags returns a lookup of the tags of a CA for Authorize.
+func caTags(app *AdminApp, name string) func() ([]string, error) {
+	return func() ([]string, error) {
+		cont, err := controller.NewCA(app.env)
+		if err != nil {
+			return nil, err
+		}
+
+		private := false
+		params := controller.NewCAParams()
+		params.Name = &name
+		params.Private = &private
+
+		ca, err := cont.Show(params)
+		if err != nil {
+			return nil, err
+		}
+		if ca == nil {
+			return nil, fmt.Errorf("CA '%s' not found", name)
+		}
+		return ca.Data.Body.Tags, nil
+	}
+}
+
+// certTags returns a lookup of the tags of a certificate for Authorize.
+func certTags(app *AdminApp, name string) func() ([]string, error) {
+	return func() ([]string, error) {
+		cont, err := controller.NewCertificate(app.env)
+		if err != nil {
+			return nil, err
+		}
+
+		private := false
+		params := controller.NewCertificateParams()
+		params.Name = &name
+		params.Private = &private
+
+		cert, err := cont.Show(params)
+		if err != nil {
+			return nil, err
+		}
+		if cert == nil {
+			return nil, fmt.Errorf("certificate '%s' not found", name)
+		}
+		return cert.Data.Body.Tags, nil
+	}
+}
+
+// csrTags returns a lookup of the tags of a CSR for Authorize.
+func csrTags(app *AdminApp, name string) func() ([]string, error) {
+	return func() ([]string, error) {
+		cont, err := controller.NewCSR(app.env)
+		if err != nil {
+			return nil, err
+		}
+
+		private := false
+		params := controller.NewCSRParams()
+		params.Name = &name
+		params.Private = &private
+
+		csr, err := cont.Show(params)
+		if err != nil {
+			return nil, err
+		}
+		if csr == nil {
+			return nil, fmt.Errorf("CSR '%s' not found", name)
+		}
+		return csr.Data.Body.Tags, nil
+	}
+}
@@ -5,18 +5,196 @@ import (
 	"fmt"
 	"github.com/jawher/mow.cli"
 	"github.com/pki-io/controller"
+	"github.com/pki-io/core/entity"
+	"sort"
+	"strconv"
+	"time"
 )
 
 func adminCmd(cmd *cli.Cmd) {
 	cmd.Command("list", "List admins", adminListCmd)
 	cmd.Command("show", "Show an admin", adminShowCmd)
 	cmd.Command("invite", "Invite a new admin", adminInviteCmd)
+	cmd.Command("invites", "List pending admin invites", adminInvitesCmd)
+	cmd.Command("invite-revoke", "Revoke a pending admin invite", adminInviteRevokeCmd)
 	cmd.Command("new", "Create a new admin", adminNewCmd)
 	cmd.Command("run", "Process admin tasks", adminRunCmd)
 	cmd.Command("complete", "Complete an admin invite", adminCompleteCmd)
+	cmd.Command("update", "Update an admin's role", adminUpdateCmd)
+	cmd.Command("approvals", "List approval requests", adminApprovalsCmd)
+	cmd.Command("approve", "Approve or deny an approval request", adminApproveCmd)
+	cmd.Command("quorum", "Show or set the number of approvals sensitive actions need", adminQuorumCmd)
 	cmd.Command("delete", "Delete an admin", adminDeleteCmd)
 }
 
+// invitesDoc is the org store document holding the admin invites, which the
+// controller can't list or revoke.
+const invitesDoc = "invites"
+
+// Invite is an admin invite and the role the admin gets once admin run
+// accepts it. Times are unix seconds, with an expiry of 0 meaning never.
+type Invite struct {
+	Name    string `json:"name"`
+	Role    *Role  `json:"role"`
+	Created int64  `json:"created"`
+	Expires int64  `json:"expires"`
+	Revoked bool   `json:"revoked"`
+	Used    bool   `json:"used"`
+}
+
+// Invites maps invite ids to invites.
+type Invites map[string]*Invite
+
+// Stale returns true if the invite has been revoked or has expired.
+func (i *Invite) Stale() bool {
+	return i.Revoked || (i.Expires != 0 && time.Now().Unix() >= i.Expires)
+}
+
+// inviteStatusDoc is the public document with the expiry and revocation of
+// each invite. Invitees can't read the org store until they have joined, and
+// admin run can't hold back the org's keys from a join request the controller
+// has queued, so admin new and admin complete check this instead.
+const inviteStatusDoc = "invite_status"
+
+// publishInvites replaces the invite status document with the expiry,
+// revocation and use of invites, leaving out their names and roles.
+func publishInvites(invites Invites) error {
+	status := make(Invites)
+	for id, invite := range invites {
+		status[id] = &Invite{Expires: invite.Expires, Revoked: invite.Revoked, Used: invite.Used}
+	}
+	return savePublicDoc(inviteStatusDoc, status)
+}
+
+// checkInvite returns an error if the invite with id has expired or been
+// revoked before admin run accepted it. Invites made before their status was
+// published pass.
+func checkInvite(id string) error {
+	status := make(Invites)
+	if err := loadPublicDoc(inviteStatusDoc, &status); err != nil {
+		return err
+	}
+	if invite, ok := status[id]; ok && !invite.Used && invite.Stale() {
+		return fmt.Errorf("invite %s has expired or been revoked, ask an admin for a new one", id)
+	}
+	return nil
+}
+
+// inviteError is the error for a failure to use an invite. The controller
+// doesn't say why, but a bad invite is the usual reason.
+func inviteError(err error) error {
+	return fmt.Errorf("could not use the invite, it may have expired, been revoked or already been used, ask an admin for a new one: %s", err)
+}
+
+func inviteOutput(id string, invite *Invite) *OutputDoc {
+	age := time.Since(time.Unix(invite.Created, 0)) / time.Minute * time.Minute
+
+	expiresText := "never"
+	if invite.Expires != 0 {
+		expiresText = formatTime(invite.Expires)
+		if invite.Stale() {
+			expiresText += " (expired)"
+		}
+	}
+
+	return NewOutputDoc().
+		Add("id", "Id", id).
+		Add("name", "Name", invite.Name).
+		Add("created", "Created", formatTime(invite.Created)).
+		Add("age", "Age", age.String()).
+		Add("expires", "Expires", expiresText)
+}
+
+// adminNames returns the names of the org's admins.
+func adminNames(cont *controller.AdminController) (map[string]bool, error) {
+	admins, err := cont.List(controller.NewAdminParams())
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, admin := range admins {
+		names[admin.Name()] = true
+	}
+	return names, nil
+}
+
+// enforceInvites marks the invites of admins who joined since before as used,
+// giving them the invite's role, and, as the controller accepts any invite it
+// knows, deletes admins who joined with only stale invites for their name.
+func enforceInvites(app *AdminApp, cont *controller.AdminController, before map[string]bool) {
+	after, err := adminNames(cont)
+	if err != nil {
+		app.Fatal(err)
+	}
+
+	roles := make(map[string]*Role)
+	invites := make(Invites)
+	err = app.Store().Update(invitesDoc, &invites, func() error {
+		for name := range after {
+			if before[name] {
+				continue
+			}
+
+			valid, stale := false, false
+			for _, invite := range invites {
+				if invite.Name != name || invite.Used {
+					continue
+				}
+				if invite.Stale() {
+					stale = true
+				} else {
+					valid = true
+					invite.Used = true
+					roles[name] = invite.Role
+				}
+			}
+
+			// The invitee can't write to the org's audit log until they have
+			// joined, so their admin new is recorded here.
+			if valid || !stale {
+				app.Audit("admin new", name, "", "")
+				continue
+			}
+
+			logger.Warnf("deleting admin '%s' who joined with an expired or revoked invite", name)
+			reason := "joined with an expired or revoked invite"
+			app.Audit("admin delete", name, "", reason)
+
+			params := controller.NewAdminParams()
+			adminName := name
+			params.Name = &adminName
+			params.ConfirmDelete = &reason
+			if err := cont.Delete(params); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		app.Fatal(err)
+	}
+	if err := publishInvites(invites); err != nil {
+		app.Fatal(err)
+	}
+
+	for name, role := range roles {
+		if role == nil {
+			continue
+		}
+		if err := setRole(app, name, role); err != nil {
+			app.Fatal(err)
+		}
+		logger.Infof("admin '%s' joined as %s", name, role)
+	}
+}
+
+func adminOutput(admin *entity.Entity, role *Role) *OutputDoc {
+	return entityOutput(admin.Id(), admin.Name(), admin.Data.Body.KeyType, admin.Data.Body.PublicSigningKey, admin.Data.Body.PublicEncryptionKey).
+		Add("role", "Role", role.Name).
+		Add("scope", "Scope", role.Scope)
+}
+
 func adminListCmd(cmd *cli.Cmd) {
 	params := controller.NewAdminParams()
 
@@ -34,14 +212,16 @@ func adminListCmd(cmd *cli.Cmd) {
 			app.Fatal(err)
 		}
 
-		table := app.NewTable()
-		table.SetHeader([]string{"Name", "Id"})
-
+		var docs []*OutputDoc
 		for _, admin := range admins {
-			table.Append([]string{admin.Name(), admin.Id()})
+			role, err := loadRole(app, admin.Name())
+			if err != nil {
+				app.Fatal(err)
+			}
+			docs = append(docs, adminOutput(admin, role))
 		}
 
-		app.RenderTable(table)
+		app.RenderList(docs, "Name", "ID", "Role")
 	}
 }
 
@@ -66,59 +246,187 @@ func adminShowCmd(cmd *cli.Cmd) {
 		}
 
 		if admin != nil {
+			role, err := loadRole(app, admin.Name())
+			if err != nil {
+				app.Fatal(err)
+			}
+			app.RenderItem(adminOutput(admin, role))
+		}
+
+	}
+}
 
-			table := app.NewTable()
+func adminInviteCmd(cmd *cli.Cmd) {
+	cmd.Spec = "NAME [OPTIONS]"
+
+	params := controller.NewAdminParams()
+	params.Name = cmd.StringArg("NAME", "", "name of admin")
 
-			adminData := [][]string{
-				[]string{"Name", admin.Name()},
-				[]string{"ID", admin.Id()},
+	expiresIn := cmd.StringOpt("expires-in", "", "time until the invite expires, e.g. 24h or 7d (default never), after which admin run rejects it")
+	role := cmd.StringOpt("role", RoleAuditor, "role of the new admin (owner, ca-operator, issuer, auditor or node-operator)")
+	scope := cmd.StringsOpt("scope", nil, "limit the role to CAs, certificates and CSRs with this tag (repeatable)")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("creating admin invite")
+
+		if err := checkRole(*role); err != nil {
+			app.Fail(err)
+		}
+		app.Authorize(PermManageAdmin, nil)
+
+		now := time.Now()
+		invite := &Invite{Name: *params.Name, Role: &Role{Name: *role, Scope: *scope}, Created: now.Unix()}
+		if *expiresIn != "" {
+			window, err := parseWindow(*expiresIn)
+			if err != nil || window <= 0 {
+				app.Fail(fmt.Errorf("invalid expiry: %s", *expiresIn))
 			}
+			invite.Expires = now.Add(window).Unix()
+		}
 
-			table.AppendBulk(adminData)
+		cont, err := controller.NewAdmin(app.env)
+		if err != nil {
+			app.Fatal(err)
+		}
 
-			app.RenderTable(table)
+		app.Audit("admin invite", *params.Name, "", invite.Role.String())
 
-			fmt.Println("")
-			fmt.Printf("Public signing key:\n%s\n", admin.Data.Body.PublicSigningKey)
-			fmt.Printf("Public encryption key:\n%s\n", admin.Data.Body.PublicEncryptionKey)
+		keyPair, err := cont.Invite(params)
+		if err != nil {
+			app.Fatal(err)
+		}
+		if len(keyPair) != 2 || keyPair[0] == "" || keyPair[1] == "" {
+			app.Fatal(fmt.Errorf("invalid invite id and key: %v", keyPair))
+		}
+
+		invites := make(Invites)
+		err = app.Store().Update(invitesDoc, &invites, func() error {
+			invites[keyPair[0]] = invite
+			return nil
+		})
+		if err != nil {
+			app.Fatal(err)
+		}
+		if err := publishInvites(invites); err != nil {
+			app.Fatal(err)
 		}
 
+		app.RenderItem(NewOutputDoc().Add("id", "Id", keyPair[0]).Add("key", "Key", keyPair[1]))
 	}
 }
 
-func adminInviteCmd(cmd *cli.Cmd) {
+func adminUpdateCmd(cmd *cli.Cmd) {
 	cmd.Spec = "NAME [OPTIONS]"
 
 	params := controller.NewAdminParams()
 	params.Name = cmd.StringArg("NAME", "", "name of admin")
 
+	roleName := cmd.StringOpt("role", "", "new role (owner, ca-operator, issuer, auditor or node-operator)")
+	scope := cmd.StringsOpt("scope", nil, "limit the role to CAs, certificates and CSRs with this tag (repeatable)")
+	clearScope := cmd.BoolOpt("clear-scope", false, "remove the role's tag scope")
+
 	cmd.Action = func() {
 		app := NewAdminApp()
-		logger.Info("creating admin invite")
+		logger.Info("updating admin")
+
+		app.Authorize(PermManageAdmin, nil)
 
 		cont, err := controller.NewAdmin(app.env)
 		if err != nil {
 			app.Fatal(err)
 		}
 
-		keyPair, err := cont.Invite(params)
+		admin, err := cont.Show(params)
 		if err != nil {
 			app.Fatal(err)
 		}
+		if admin == nil {
+			app.Fail(fmt.Errorf("admin '%s' not found", *params.Name))
+		}
 
-		if len(keyPair) > 0 {
-			table := app.NewTable()
+		current, err := loadRole(app, *params.Name)
+		if err != nil {
+			app.Fatal(err)
+		}
 
-			adminData := [][]string{
-				[]string{"Id", keyPair[0]},
-				[]string{"Key", keyPair[1]},
-			}
+		role := &Role{Name: *roleName, Scope: *scope}
+		if role.Name == "" {
+			role.Name = current.Name
+		}
+		if err := checkRole(role.Name); err != nil {
+			app.Fail(err)
+		}
+
+		if len(role.Scope) == 0 && !*clearScope {
+			role.Scope = current.Scope
+		}
+
+		app.Audit("admin update", *params.Name, "", role.String())
+
+		if err := setRole(app, *params.Name, role); err != nil {
+			app.Fail(err)
+		}
+		app.RenderItem(NewOutputDoc().Add("name", "Name", *params.Name).Add("role", "Role", role.Name).Add("scope", "Scope", role.Scope))
+	}
+}
+
+func adminInvitesCmd(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("listing admin invites")
+
+		invites := make(Invites)
+		if _, err := app.Store().Load(invitesDoc, &invites); err != nil {
+			app.Fatal(err)
+		}
 
-			table.AppendBulk(adminData)
+		var ids []string
+		for id, invite := range invites {
+			if !invite.Used && !invite.Revoked {
+				ids = append(ids, id)
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool { return invites[ids[i]].Created < invites[ids[j]].Created })
 
-			app.RenderTable(table)
+		var docs []*OutputDoc
+		for _, id := range ids {
+			docs = append(docs, inviteOutput(id, invites[id]))
 		}
 
+		app.RenderList(docs, "Id", "Name", "Created", "Age", "Expires")
+	}
+}
+
+func adminInviteRevokeCmd(cmd *cli.Cmd) {
+	cmd.Spec = "ID [OPTIONS]"
+
+	params := controller.NewAdminParams()
+	params.InviteId = cmd.StringArg("ID", "", "invite id")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("revoking admin invite")
+
+		app.Authorize(PermManageAdmin, nil)
+
+		app.Audit("admin invite-revoke", *params.InviteId, "", "")
+
+		invites := make(Invites)
+		err := app.Store().Update(invitesDoc, &invites, func() error {
+			invite, ok := invites[*params.InviteId]
+			if !ok || invite.Used || invite.Revoked {
+				return fmt.Errorf("no pending invite with id %s", *params.InviteId)
+			}
+			invite.Revoked = true
+			return nil
+		})
+		if err != nil {
+			app.Fail(err)
+		}
+		if err := publishInvites(invites); err != nil {
+			app.Fatal(err)
+		}
 	}
 }
 
@@ -135,13 +443,17 @@ func adminNewCmd(cmd *cli.Cmd) {
 		app := NewAdminApp()
 		logger.Info("creating new admin")
 
+		if err := checkInvite(*params.InviteId); err != nil {
+			app.Fail(err)
+		}
+
 		cont, err := controller.NewAdmin(app.env)
 		if err != nil {
 			app.Fatal(err)
 		}
 
 		if err := cont.New(params); err != nil {
-			app.Fatal(err)
+			app.Fail(inviteError(err))
 		}
 
 	}
@@ -159,10 +471,41 @@ func adminRunCmd(cmd *cli.Cmd) {
 			app.Fatal(err)
 		}
 
+		before, err := adminNames(cont)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		// The controller sends the org's keys to every invitee whose join
+		// request it has queued, so the status of invites made before it was
+		// published has to be out before any of them completes
+		invites := make(Invites)
+		if _, err := app.Store().Load(invitesDoc, &invites); err != nil {
+			app.Fatal(err)
+		}
+		if err := publishInvites(invites); err != nil {
+			app.Fatal(err)
+		}
+
 		if err := cont.Run(params); err != nil {
 			app.Fatal(err)
 		}
 
+		enforceInvites(app, cont, before)
+
+		approvals, err := loadApprovals(app)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		if ids := approvals.Ids(ApprovalPending); len(ids) > 0 {
+			logger.Infof("%d approval requests are waiting, use 'admin approve ID' to approve or deny them", len(ids))
+			var docs []*OutputDoc
+			for _, id := range ids {
+				docs = append(docs, approvals.Output(id))
+			}
+			app.RenderList(docs, "Id", "Action", "Requester", "Created", "Approvals", "Denials")
+		}
 	}
 }
 
@@ -179,15 +522,26 @@ func adminCompleteCmd(cmd *cli.Cmd) {
 		app := NewAdminApp()
 		logger.Info("completing new admin")
 
+		if err := checkInvite(*params.InviteId); err != nil {
+			app.Fail(err)
+		}
+
 		cont, err := controller.NewAdmin(app.env)
 		if err != nil {
 			app.Fatal(err)
 		}
 
 		if err := cont.Complete(params); err != nil {
+			app.Fail(inviteError(err))
+		}
+
+		if err := setCurrentAdmin(app, *params.Name); err != nil {
 			app.Fatal(err)
 		}
 
+		// Only now can the new admin write to the org's audit log.
+		app.Audit("admin complete", *params.Name, "", "")
+
 	}
 }
 
@@ -203,14 +557,131 @@ func adminDeleteCmd(cmd *cli.Cmd) {
 		app := NewAdminApp()
 		logger.Info("deleting admin")
 
+		app.Authorize(PermManageAdmin, nil)
+
 		cont, err := controller.NewAdmin(app.env)
 		if err != nil {
 			app.Fatal(err)
 		}
 
+		app.Audit("admin delete", *params.Name, "", *params.ConfirmDelete)
+
+		if err := setRole(app, *params.Name, nil); err != nil {
+			app.Fail(err)
+		}
+
 		if err := cont.Delete(params); err != nil {
 			app.Fatal(err)
 		}
+	}
+}
+
+func adminApprovalsCmd(cmd *cli.Cmd) {
+	cmd.Spec = "[OPTIONS]"
+
+	status := cmd.StringOpt("status", "", "only list requests with this status (pending, approved, denied or completed)")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("listing approval requests")
+
+		switch *status {
+		case "", ApprovalPending, ApprovalApproved, ApprovalDenied, ApprovalCompleted:
+		default:
+			app.Fail(fmt.Errorf("invalid status: %s", *status))
+		}
+
+		approvals, err := loadApprovals(app)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		var docs []*OutputDoc
+		for _, id := range approvals.Ids(*status) {
+			docs = append(docs, approvals.Output(id))
+		}
+
+		app.RenderList(docs, "Id", "Action", "Requester", "Created", "Approvals", "Denials", "Status")
+	}
+}
+
+func adminApproveCmd(cmd *cli.Cmd) {
+	cmd.Spec = "ID [OPTIONS]"
+
+	approvalId := cmd.StringArg("ID", "", "approval request id")
+	deny := cmd.BoolOpt("deny", false, "deny the request instead of approving it")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("voting on approval request")
+
+		vote := "admin approve"
+		if *deny {
+			vote = "admin deny"
+		}
+		app.Audit(vote, *approvalId, "", "")
+
+		approvals := app.Vote(*approvalId, *deny)
+		app.RenderItem(approvals.Output(*approvalId))
+	}
+}
+
+func adminQuorumCmd(cmd *cli.Cmd) {
+	cmd.Spec = "[QUORUM] [OPTIONS]"
+
+	quorum := cmd.IntArg("QUORUM", 0, "number of admins that must approve sensitive actions")
+	approvalId := cmd.StringOpt("approval", "", "id of an approved request for this change")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+
+		if *quorum == 0 {
+			logger.Info("showing approval quorum")
+			approvals, err := loadApprovals(app)
+			if err != nil {
+				app.Fatal(err)
+			}
+			app.RenderItem(NewOutputDoc().Add("quorum", "Quorum", approvals.Quorum))
+			return
+		}
+
+		logger.Info("setting approval quorum")
+		if *quorum < 1 {
+			app.Fail(fmt.Errorf("invalid quorum: %d", *quorum))
+		}
+
+		app.Authorize(PermManageOrg, nil)
+
+		cont, err := controller.NewAdmin(app.env)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		admins, err := adminNames(cont)
+		if err != nil {
+			app.Fatal(err)
+		}
+		if *quorum > len(admins) {
+			app.Fail(fmt.Errorf("a quorum of %d needs more than the org's %d admins", *quorum, len(admins)))
+		}
+
+		action := fmt.Sprintf(ActionQuorumSet, *quorum)
+		app.Approve(action, *approvalId)
+
+		app.Audit("admin quorum", strconv.Itoa(*quorum), "", "")
+
+		// The approval is used up under the quorum it was given for
+		_, err = updateApprovals(app, func(approvals *Approvals) error {
+			if err := approvals.complete(action, *approvalId); err != nil {
+				return err
+			}
+			approvals.Quorum = *quorum
+			return nil
+		})
+		if err != nil {
+			app.Fatal(err)
+		}
 
+		app.RenderItem(NewOutputDoc().Add("quorum", "Quorum", *quorum))
 	}
 }
@@ -0,0 +1,131 @@
+// ThreatSpec package main
+package main
+
+import (
+	"fmt"
+	"github.com/jawher/mow.cli"
+	"strings"
+)
+
+func auditCmd(cmd *cli.Cmd) {
+	cmd.Command("list", "List audit log entries", auditListCmd)
+	cmd.Command("show", "Show an audit log entry", auditShowCmd)
+	cmd.Command("verify", "Verify the audit log hasn't been edited", auditVerifyCmd)
+}
+
+func auditListCmd(cmd *cli.Cmd) {
+	cmd.Spec = "[OPTIONS]"
+
+	action := cmd.StringOpt("action", "", "only list entries whose action starts with this, e.g. 'ca'")
+	admin := cmd.StringOpt("admin", "", "only list entries made by this admin")
+	target := cmd.StringOpt("target", "", "only list entries for this target name or id")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("listing audit log")
+
+		app.Authorize(PermRead, nil)
+
+		entries, err := loadAuditLog(app)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		var docs []*OutputDoc
+		for _, entry := range entries {
+			if *action != "" && !strings.HasPrefix(entry.Action, *action) {
+				continue
+			}
+			if *admin != "" && entry.Admin != *admin {
+				continue
+			}
+			if *target != "" && entry.Target != *target && entry.TargetId != *target {
+				continue
+			}
+			docs = append(docs, entry.Output())
+		}
+
+		app.RenderList(docs, "Seq", "Time", "Admin", "Action", "Target", "Reason")
+	}
+}
+
+func auditShowCmd(cmd *cli.Cmd) {
+	cmd.Spec = "SEQ [OPTIONS]"
+
+	seq := cmd.IntArg("SEQ", 0, "sequence number of the entry")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("showing audit log entry")
+
+		app.Authorize(PermRead, nil)
+
+		entries, err := loadAuditLog(app)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		for _, entry := range entries {
+			if entry.Seq == *seq {
+				app.RenderItem(entry.Output())
+				return
+			}
+		}
+
+		app.Fail(fmt.Errorf("audit entry %d doesn't exist", *seq))
+	}
+}
+
+func auditVerifyCmd(cmd *cli.Cmd) {
+	cmd.Spec = "[OPTIONS]"
+
+	head := cmd.StringOpt("head", "", "hash of an entry recorded earlier, which must still be in the log")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("verifying audit log")
+
+		app.Authorize(PermRead, nil)
+
+		entries, err := loadAuditLog(app)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		seen, err := loadAuditHead(app)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		keys, problems, err := pinAuditKeys(app)
+		if err != nil {
+			app.Fatal(err)
+		}
+		problems = append(problems, verifyAuditLog(entries, keys, seen)...)
+
+		if *head != "" {
+			found := false
+			for _, entry := range entries {
+				found = found || entry.Hash == *head
+			}
+			if !found {
+				problems = append(problems, NewOutputDoc().Add("seq", "Seq", 0).Add("problem", "Problem", fmt.Sprintf("hash %s isn't in the log, entries may have been removed", *head)))
+			}
+		}
+
+		if len(problems) > 0 {
+			app.RenderList(problems, "Seq", "Problem")
+			logger.Errorf("audit log failed verification with %d problems", len(problems))
+			app.ExitWith(1)
+		}
+
+		headHash := auditGenesis
+		if len(entries) > 0 {
+			headHash = entries[len(entries)-1].Hash
+		}
+		if err := saveAuditHead(app, &auditHead{Seq: len(entries), Hash: headHash}); err != nil {
+			app.Fatal(err)
+		}
+		app.RenderItem(NewOutputDoc().Add("entries", "Entries", len(entries)).Add("head", "Head", headHash))
+	}
+}
@@ -2,24 +2,168 @@
 package main
 
 import (
+	cx509 "crypto/x509"
 	"fmt"
 	"github.com/jawher/mow.cli"
 	"github.com/pki-io/controller"
-	"strconv"
+	"github.com/pki-io/core/x509"
+	"net/http"
+	"strings"
+	"time"
 )
 
 // ThreatSpec TMv0.1 for caCmd
 // Does CA CLI handling for App:CLI
-// Calls main.caNewCmd main.caListCmd main.caShowCmd main.caUpdateCmd main.caDeleteCmd
+// Calls main.caNewCmd main.caListCmd main.caShowCmd main.caUpdateCmd main.caCRLCmd main.caOCSPServeCmd main.caTruststoreCmd main.caDeleteCmd
 
 func caCmd(cmd *cli.Cmd) {
 	cmd.Command("new", "Create a new CA", caNewCmd)
 	cmd.Command("list", "List CAs", caListCmd)
 	cmd.Command("show", "Show a CA", caShowCmd)
 	cmd.Command("update", "Update an existing CA", caUpdateCmd)
+	cmd.Command("crl", "Generate a CRL for a CA", caCRLCmd)
+	cmd.Command("ocsp-serve", "Run an OCSP responder for a CA", caOCSPServeCmd)
+	cmd.Command("truststore", "Export a truststore of CA certificates", caTruststoreCmd)
 	cmd.Command("delete", "Delete a CA", caDeleteCmd)
 }
 
+// caChain returns the issuing CAs of ca, from the immediate parent up to the
+// root, by matching certificates from the given list of org CAs.
+func caChain(ca *x509.CA, cas []*x509.CA) ([]*x509.CA, error) {
+	var candidates []string
+	for _, c := range cas {
+		candidates = append(candidates, c.Data.Body.Certificate)
+	}
+
+	indexes, err := issuerChain(ca.Data.Body.Certificate, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	var chain []*x509.CA
+	for _, i := range indexes {
+		chain = append(chain, cas[i])
+	}
+
+	return chain, nil
+}
+
+func loadCAChain(app *AdminApp, cont *controller.CAController, ca *x509.CA) []*x509.CA {
+	cas, err := cont.List(controller.NewCAParams())
+	if err != nil {
+		app.Fatal(err)
+	}
+
+	chain, err := caChain(ca, cas)
+	if err != nil {
+		app.Fatal(err)
+	}
+
+	return chain
+}
+
+func caChainPEM(chain []*x509.CA) string {
+	var pems []string
+	for _, ca := range chain {
+		pems = append(pems, strings.TrimSpace(ca.Data.Body.Certificate))
+	}
+	return strings.Join(pems, "\n")
+}
+
+func caOutput(ca *x509.CA, chain []*x509.CA, private bool) *OutputDoc {
+	dn := ca.Data.Body.DNScope
+	dnScope := NewOutputDoc().
+		Add("country", "Country DN scope", dn.Country).
+		Add("organization", "Organization DN scope", dn.Organization).
+		Add("organizational_unit", "Organizational unit DN scope", dn.OrganizationalUnit).
+		Add("locality", "Locality DN scope", dn.Locality).
+		Add("province", "Province DN scope", dn.Province).
+		Add("street_address", "Street address DN scope", dn.StreetAddress).
+		Add("postal_code", "Postal code DN scope", dn.PostalCode)
+
+	doc := NewOutputDoc().
+		Add("id", "ID", ca.Id()).
+		Add("name", "Name", ca.Name()).
+		Add("tags", "Tags", ca.Data.Body.Tags).
+		Add("key_type", "Key type", ca.Data.Body.KeyType).
+		Add("ca_expiry", "CA expiry period (days)", ca.Data.Body.CAExpiry).
+		Add("cert_expiry", "Cert expiry period (days)", ca.Data.Body.CertExpiry).
+		Add("dn_scope", "DN scope", dnScope)
+
+	if c, err := parseCertificate(ca.Data.Body.Certificate); err != nil {
+		logger.Warnf("could not parse certificate of CA '%s': %s", ca.Name(), err)
+	} else {
+		addValidity(doc, c)
+	}
+
+	chainDocs := []*OutputDoc{}
+	for _, c := range chain {
+		chainDocs = append(chainDocs, NewOutputDoc().Add("id", "Id", c.Id()).Add("name", "Name", c.Name()))
+	}
+	doc.Add("chain", "Chain", chainDocs)
+	doc.AddBlock("certificate", "Certificate", ca.Data.Body.Certificate)
+
+	if len(chain) > 0 {
+		doc.AddBlock("chain_certificates", "Chain certificates", caChainPEM(chain))
+	}
+
+	if private {
+		doc.AddBlock("private_key", "Private key", ca.Data.Body.PrivateKey)
+	}
+
+	return doc
+}
+
+// newIssuedCA creates a CA signed by the parent CA, or a self-signed root CA
+// if parent is empty, with a path length limit unless maxPathLen is negative.
+// The controller only creates unconstrained roots, so the CA is issued here
+// and then imported.
+func newIssuedCA(app *AdminApp, cont *controller.CAController, params *controller.CAParams, parent string, maxPathLen int) (*x509.CA, error) {
+	if *params.CertFile != "" || *params.KeyFile != "" {
+		app.Fail(fmt.Errorf("--cert and --key can't be used with --parent or --max-path-len"))
+	}
+
+	var parentCert, parentKey string
+	if parent != "" {
+		private := true
+		parentParams := controller.NewCAParams()
+		parentParams.Name = &parent
+		parentParams.Private = &private
+
+		ca, err := cont.Show(parentParams)
+		if err != nil {
+			return nil, err
+		}
+		if ca == nil {
+			app.Fail(fmt.Errorf("parent CA '%s' not found", parent))
+		}
+		parentCert, parentKey = ca.Data.Body.Certificate, ca.Data.Body.PrivateKey
+	}
+
+	template := &cx509.Certificate{
+		Subject:               subjectName(*params.Name, *params.DnCountry, *params.DnState, *params.DnLocality, *params.DnOrg, *params.DnOrgUnit, *params.DnStreet, *params.DnPostal),
+		KeyUsage:              cx509.KeyUsageCertSign | cx509.KeyUsageCRLSign | cx509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            maxPathLen,
+		MaxPathLenZero:        maxPathLen == 0,
+	}
+
+	certPEM, keyPEM, err := issueCertificate(template, *params.CaExpiry, *params.KeyType, "", parentCert, parentKey)
+	if err != nil {
+		app.Fail(err)
+	}
+
+	var ca *x509.CA
+	err = withTempFiles([]string{certPEM, keyPEM}, func(paths []string) error {
+		params.CertFile = &paths[0]
+		params.KeyFile = &paths[1]
+		ca, err = cont.New(params)
+		return err
+	})
+	return ca, err
+}
+
 // ThreatSpec TMv0.1 for caNewCmd
 // Does new CA CLI handling for App:CLI
 // Calls main.controller.NewCA main.CAController.New
@@ -33,42 +177,53 @@ func caNewCmd(cmd *cli.Cmd) {
 	params.CertFile = cmd.StringOpt("cert", "", "certificate PEM file")
 	params.KeyFile = cmd.StringOpt("key", "", "key PEM file")
 	params.Tags = cmd.StringOpt("tags", "NAME", "comma separated list of tags")
-	params.CaExpiry = cmd.IntOpt("ca-expiry", 365, "CA expiry period in days")
-	params.CertExpiry = cmd.IntOpt("cert-expiry", 90, "Certificate expiry period in days")
-	params.KeyType = cmd.StringOpt("key-type", "ec", "Key type (ec or rsa)")
-	params.DnLocality = cmd.StringOpt("dn-l", "", "Locality for DN scope")
-	params.DnState = cmd.StringOpt("dn-st", "", "State/province for DN scope")
-	params.DnOrg = cmd.StringOpt("dn-o", "", "Organization for DN scope")
-	params.DnOrgUnit = cmd.StringOpt("dn-ou", "", "Organizational unit for DN scope")
-	params.DnCountry = cmd.StringOpt("dn-c", "", "Country for DN scope")
-	params.DnStreet = cmd.StringOpt("dn-street", "", "Street for DN scope")
-	params.DnPostal = cmd.StringOpt("dn-postal", "", "PostalCode for DN scope")
+	params.CaExpiry = cmd.IntOpt("ca-expiry", profile.Int(ProfileCAExpiry, 365), "CA expiry period in days")
+	params.CertExpiry = cmd.IntOpt("cert-expiry", profile.Int(ProfileCertExpiry, 90), "Certificate expiry period in days")
+	params.KeyType = cmd.StringOpt("key-type", profile.String(ProfileKeyType, "ec"), "Key type (ec or rsa)")
+	parent := cmd.StringOpt("parent", "", "name of the parent CA (self-signed root by default)")
+	maxPathLen := cmd.IntOpt("max-path-len", -1, "maximum number of intermediate CAs below this CA (-1 for no limit)")
+	params.DnLocality = cmd.StringOpt("dn-l", profile.String(ProfileDnLocality, ""), "Locality for DN scope")
+	params.DnState = cmd.StringOpt("dn-st", profile.String(ProfileDnState, ""), "State/province for DN scope")
+	params.DnOrg = cmd.StringOpt("dn-o", profile.String(ProfileDnOrg, ""), "Organization for DN scope")
+	params.DnOrgUnit = cmd.StringOpt("dn-ou", profile.String(ProfileDnOrgUnit, ""), "Organizational unit for DN scope")
+	params.DnCountry = cmd.StringOpt("dn-c", profile.String(ProfileDnCountry, ""), "Country for DN scope")
+	params.DnStreet = cmd.StringOpt("dn-street", profile.String(ProfileDnStreet, ""), "Street for DN scope")
+	params.DnPostal = cmd.StringOpt("dn-postal", profile.String(ProfileDnPostal, ""), "PostalCode for DN scope")
 
 	cmd.Action = func() {
 		app := NewAdminApp()
 		logger.Info("creating new CA")
 
+		app.Authorize(PermManageCA, tagsOf(entityTags(*params.Tags, *params.Name)))
+		if *parent != "" {
+			app.Authorize(PermIssue, caTags(app, *parent))
+		}
+
 		cont, err := controller.NewCA(app.env)
 		if err != nil {
 			app.Fatal(err)
 		}
 
-		ca, err := cont.New(params)
+		app.Audit("ca new", *params.Name, "", "")
+
+		var ca *x509.CA
+		if *parent == "" && *maxPathLen < 0 {
+			ca, err = cont.New(params)
+		} else {
+			ca, err = newIssuedCA(app, cont, params, *parent, *maxPathLen)
+		}
 		if err != nil {
 			app.Fatal(err)
 		}
 
 		if ca != nil {
-			table := app.NewTable()
-
-			caData := [][]string{
-				[]string{"Id", ca.Id()},
-				[]string{"Name", ca.Name()},
+			var chain []*x509.CA
+			if *parent != "" {
+				chain = loadCAChain(app, cont, ca)
 			}
-
-			table.AppendBulk(caData)
-
-			app.RenderTable(table)
+			app.RenderResult(caOutput(ca, chain, false), NewOutputDoc().
+				Add("id", "Id", ca.Id()).
+				Add("name", "Name", ca.Name()))
 		}
 
 	}
@@ -79,6 +234,9 @@ func caNewCmd(cmd *cli.Cmd) {
 func caListCmd(cmd *cli.Cmd) {
 	params := controller.NewCAParams()
 
+	expiringWithin := cmd.StringOpt("expiring-within", "", "only list CAs expiring within a period, e.g. 30d")
+	expired := cmd.BoolOpt("expired", false, "only list expired CAs")
+
 	cmd.Action = func() {
 		app := NewAdminApp()
 		logger.Info("listing CAs")
@@ -93,14 +251,28 @@ func caListCmd(cmd *cli.Cmd) {
 			app.Fatal(err)
 		}
 
-		table := app.NewTable()
-		table.SetHeader([]string{"Name", "Id"})
+		filter, err := NewExpiryFilter(*expiringWithin, *expired)
+		if err != nil {
+			app.Fail(err)
+		}
 
+		var docs []*OutputDoc
 		for _, ca := range cas {
-			table.Append([]string{ca.Name(), ca.Id()})
+			if filter.Active() {
+				c, err := parseCertificate(ca.Data.Body.Certificate)
+				if err != nil || !filter.Match(c.NotAfter) {
+					continue
+				}
+			}
+
+			chain, err := caChain(ca, cas)
+			if err != nil {
+				app.Fatal(err)
+			}
+			docs = append(docs, caOutput(ca, chain, false))
 		}
 
-		app.RenderTable(table)
+		app.RenderList(docs, "Name", "ID", "Serial", "Issuer", "Not before", "Not after")
 	}
 }
 
@@ -112,13 +284,32 @@ func caShowCmd(cmd *cli.Cmd) {
 	params := controller.NewCAParams()
 	params.Name = cmd.StringArg("NAME", "", "name of CA")
 
-	params.Export = cmd.StringOpt("export", "", "tar.gz export to file")
+	params.Export = cmd.StringOpt("export", "", "export to file")
 	params.Private = cmd.BoolOpt("private", false, "show/export private data")
+	exportParams := NewExportParams()
+	exportParams.Format = cmd.StringOpt("export-format", ExportTGZ, "export format (tgz, p12, jks, pem-bundle or der)")
+	exportParams.Force = cmd.BoolOpt("force", false, "overwrite an existing export file")
+	exportParams.Dir = cmd.StringOpt("export-dir", "", "export files into a directory")
+	exportParams.Fullchain = cmd.BoolOpt("fullchain", false, "also write the certificate and CA chain to fullchain.pem with --export-dir")
+	exportParams.Names = cmd.StringsOpt("export-name", nil, "file name template for --export-dir as TYPE=TEMPLATE, e.g. key={name}.key (repeatable)")
+	exportParams.Passphrase.Env = cmd.StringOpt("passphrase-env", "", "environment variable holding the p12/jks export passphrase")
+	exportParams.Passphrase.File = cmd.StringOpt("passphrase-file", "", "file holding the p12/jks export passphrase")
+	approvalId := cmd.StringOpt("approval", "", "id of an approved request for --private")
 
 	cmd.Action = func() {
 		app := NewAdminApp()
 		logger.Info("showing CA")
 
+		if err := exportParams.Check(*params.Export); err != nil {
+			app.Fail(err)
+		}
+
+		action := fmt.Sprintf(ActionCAPrivate, *params.Name)
+		if *params.Private {
+			app.Authorize(PermReadPrivate, caTags(app, *params.Name))
+			app.Approve(action, *approvalId)
+		}
+
 		cont, err := controller.NewCA(app.env)
 		if err != nil {
 			app.Fatal(err)
@@ -129,45 +320,40 @@ func caShowCmd(cmd *cli.Cmd) {
 			app.Fatal(err)
 		}
 
-		if *params.Export == "" {
-			table := app.NewTable()
-
-			caData := [][]string{
-				[]string{"ID", ca.Id()},
-				[]string{"Name", ca.Name()},
-				[]string{"Key type", ca.Data.Body.KeyType},
-				[]string{"CA expiry period (days)", strconv.Itoa(ca.Data.Body.CAExpiry)},
-				[]string{"Cert expiry period (days)", strconv.Itoa(ca.Data.Body.CertExpiry)},
-				[]string{"Country DN scope", ca.Data.Body.DNScope.Country},
-				[]string{"Organization DN scope", ca.Data.Body.DNScope.Organization},
-				[]string{"Organizational unit DN scope", ca.Data.Body.DNScope.OrganizationalUnit},
-				[]string{"Locality DN scope", ca.Data.Body.DNScope.Locality},
-				[]string{"Province DN scope", ca.Data.Body.DNScope.Province},
-				[]string{"Street address DN scope", ca.Data.Body.DNScope.StreetAddress},
-				[]string{"Postal code DN scope", ca.Data.Body.DNScope.PostalCode},
-			}
-			table.AppendBulk(caData)
-
-			app.RenderTable(table)
+		if *params.Private {
+			app.Audit("ca show --private", *params.Name, ca.Id(), "")
+		}
 
-			fmt.Println("")
-			fmt.Printf("Certificate:\n%s\n", ca.Data.Body.Certificate)
+		chain := loadCAChain(app, cont, ca)
 
-			if *params.Private {
-				fmt.Printf("Private key:\n%s\n", ca.Data.Body.PrivateKey)
-			}
+		if *params.Export == "" && *exportParams.Dir == "" {
+			app.RenderItem(caOutput(ca, chain, *params.Private))
 		} else {
 			var files []ExportFile
 			certFile := fmt.Sprintf("%s-cert.pem", ca.Data.Body.Name)
 			keyFile := fmt.Sprintf("%s-key.pem", ca.Data.Body.Name)
+			chainFile := fmt.Sprintf("%s-cacert.pem", ca.Data.Body.Name)
 
-			files = append(files, ExportFile{Name: certFile, Mode: 0644, Content: []byte(ca.Data.Body.Certificate)})
+			files = append(files, NewExportFile(certFile, ExportFileCert, 0644, []byte(ca.Data.Body.Certificate)))
+
+			if len(chain) > 0 {
+				files = append(files, NewExportFile(chainFile, ExportFileCACert, 0644, []byte(caChainPEM(chain)+"\n")))
+			}
 
 			if *params.Private {
-				files = append(files, ExportFile{Name: keyFile, Mode: 0600, Content: []byte(ca.Data.Body.PrivateKey)})
+				files = append(files, NewExportFile(keyFile, ExportFileKey, 0600, []byte(ca.Data.Body.PrivateKey)))
+			}
+
+			*exportParams.Alias = ca.Data.Body.Name
+
+			logger.Debugf("exporting CA '%s'", ca.Data.Body.Name)
+			if err := Export(files, ca.Data.Body.Name, *params.Export, exportParams); err != nil {
+				app.Fatal(err)
 			}
+		}
 
-			Export(files, *params.Export)
+		if *params.Private {
+			app.CompleteApproval(action, *approvalId)
 		}
 	}
 }
@@ -197,17 +383,234 @@ func caUpdateCmd(cmd *cli.Cmd) {
 		app := NewAdminApp()
 		logger.Info("updating CA")
 
+		app.Authorize(PermManageCA, caTags(app, *params.Name))
+
 		cont, err := controller.NewCA(app.env)
 		if err != nil {
 			app.Fatal(err)
 		}
 
+		app.Audit("ca update", *params.Name, "", "")
+
 		if err := cont.Update(params); err != nil {
 			app.Fatal(err)
 		}
 	}
 }
 
+// ThreatSpec TMv0.1 for caCRLCmd
+// Does CRL generation for App:CLI
+// Calls main.controller.NewCA main.CAController.Show main.OrgStore.Update main.signCRL
+
+func caCRLCmd(cmd *cli.Cmd) {
+	cmd.Spec = "NAME [OPTIONS]"
+
+	params := controller.NewCAParams()
+	params.Name = cmd.StringArg("NAME", "", "name of CA")
+
+	params.Export = cmd.StringOpt("export", "", "PEM export to file")
+	nextUpdate := cmd.IntOpt("next-update", 7, "days until the next CRL update")
+	force := cmd.BoolOpt("force", false, "overwrite an existing export file")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("generating CRL")
+
+		app.Authorize(PermManageCA, caTags(app, *params.Name))
+
+		if *nextUpdate <= 0 {
+			app.Fail(fmt.Errorf("invalid next update period: %d days", *nextUpdate))
+		}
+
+		cont, err := controller.NewCA(app.env)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		private := true
+		params.Private = &private
+
+		ca, err := cont.Show(params)
+		if err != nil {
+			app.Fatal(err)
+		}
+		if ca == nil {
+			app.Fail(fmt.Errorf("CA '%s' not found", *params.Name))
+		}
+		if ca.Data.Body.PrivateKey == "" {
+			app.Fail(fmt.Errorf("CA '%s' has no private key to sign a CRL with", *params.Name))
+		}
+
+		// Each CRL gets the next number, so the number is kept with the
+		// revocations
+		app.Audit("ca crl", *params.Name, ca.Id(), "")
+
+		var crlPEM string
+		revocations := new(Revocations)
+		err = app.Store().Update(revocationsDoc, revocations, func() error {
+			number := revocations.NextCRLNumber(ca.Id())
+			crlPEM, err = signCRL(ca.Data.Body.Certificate, ca.Data.Body.PrivateKey, revocations.ForCA(ca.Id()), number, time.Duration(*nextUpdate)*24*time.Hour)
+			return err
+		})
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		if *params.Export == "" {
+			crl, err := parseCRL(crlPEM)
+			if err != nil {
+				app.Fatal(err)
+			}
+
+			number, err := crlNumber(crl)
+			if err != nil {
+				app.Fatal(err)
+			}
+
+			doc := NewOutputDoc().Add("ca", "CA", *params.Name)
+			if number != nil {
+				doc.Add("number", "CRL number", number.String())
+			}
+			doc.Add("this_update", "This update", crl.TBSCertList.ThisUpdate.UTC().Format(time.RFC3339)).
+				Add("next_update", "Next update", crl.TBSCertList.NextUpdate.UTC().Format(time.RFC3339)).
+				Add("revoked", "Revoked certificates", len(crl.TBSCertList.RevokedCertificates)).
+				AddBlock("crl", "CRL", crlPEM)
+
+			app.RenderItem(doc)
+		} else {
+			logger.Debugf("exporting to '%s'", *params.Export)
+			if err := WriteExport([]byte(crlPEM), *params.Export, 0644, -1, -1, *force); err != nil {
+				app.Fatal(err)
+			}
+		}
+	}
+}
+
+// ThreatSpec TMv0.1 for caTruststoreCmd
+
+func caTruststoreCmd(cmd *cli.Cmd) {
+	cmd.Spec = "--export [OPTIONS]"
+
+	params := controller.NewCAParams()
+
+	params.Export = cmd.StringOpt("export", "", "export to file")
+	format := cmd.StringOpt("format", ExportJKS, "truststore format (jks or p12)")
+	names := cmd.StringsOpt("ca", nil, "name of CA to trust (repeatable, defaults to all CAs)")
+	force := cmd.BoolOpt("force", false, "overwrite an existing export file")
+	passphrase := new(Passphrase)
+	passphrase.Env = cmd.StringOpt("passphrase-env", "", "environment variable holding the truststore passphrase")
+	passphrase.File = cmd.StringOpt("passphrase-file", "", "file holding the truststore passphrase")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("exporting truststore")
+
+		if *format != ExportJKS && *format != ExportP12 {
+			app.Fatal(fmt.Errorf("invalid truststore format: %s", *format))
+		}
+
+		cont, err := controller.NewCA(app.env)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		cas, err := cont.List(params)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		wanted := make(map[string]bool)
+		for _, name := range *names {
+			wanted[name] = true
+		}
+
+		var files []ExportFile
+		for _, ca := range cas {
+			name := ca.Data.Body.Name
+			if len(wanted) > 0 && !wanted[name] {
+				continue
+			}
+			delete(wanted, name)
+			files = append(files, NewExportFile(name, ExportFileCACert, 0644, []byte(ca.Data.Body.Certificate)))
+		}
+
+		for name := range wanted {
+			app.Fatal(fmt.Errorf("CA '%s' not found", name))
+		}
+
+		secret, err := passphrase.Read()
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		content, err := TrustStore(files, *format, secret)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		logger.Debugf("exporting to '%s'", *params.Export)
+		if err := WriteExport(content, *params.Export, 0644, -1, -1, *force); err != nil {
+			app.Fatal(err)
+		}
+	}
+}
+
+// ThreatSpec TMv0.1 for caOCSPServeCmd
+// Does OCSP responder handling for App:OCSP
+// Receives OCSP request from User:Client to App:OCSP
+// Calls main.OCSPResponder.ServeHTTP
+
+func caOCSPServeCmd(cmd *cli.Cmd) {
+	cmd.Spec = "NAME [OPTIONS]"
+
+	name := cmd.StringArg("NAME", "", "name of CA")
+	listen := cmd.StringOpt("listen", ":8080", "address to listen on")
+	signerCert := cmd.StringOpt("signer-cert", "", "delegated OCSP signing certificate PEM file")
+	signerKey := cmd.StringOpt("signer-key", "", "delegated OCSP signing key PEM file")
+	cacheTTL := cmd.StringOpt("cache-ttl", "1h", "how long responses are cached and valid for")
+	prefix := cmd.StringOpt("path", "/", "URL path that GET requests are below")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("starting OCSP responder")
+
+		app.Authorize(PermManageCA, caTags(app, *name))
+
+		ttl, err := time.ParseDuration(*cacheTTL)
+		if err != nil || ttl <= 0 {
+			app.Fail(fmt.Errorf("invalid cache TTL: %s", *cacheTTL))
+		}
+
+		responder := NewOCSPResponder(app, *name, ttl, *prefix)
+
+		if *signerCert != "" || *signerKey != "" {
+			if *signerCert == "" || *signerKey == "" {
+				app.Fail(fmt.Errorf("both --signer-cert and --signer-key are required for a delegated signer"))
+			}
+			if err := responder.UseSigner(*signerCert, *signerKey); err != nil {
+				app.Fail(err)
+			}
+		}
+
+		// A delegated signer that clients would reject is the user's to
+		// fix rather than a bug
+		if err := responder.CheckSigner(); err != nil {
+			app.Fail(err)
+		}
+
+		if err := responder.Load(); err != nil {
+			app.Fatal(err)
+		}
+
+		logger.Infof("OCSP responder for CA '%s' listening on %s", *name, *listen)
+		logger.Flush()
+
+		if err := http.ListenAndServe(*listen, responder); err != nil {
+			app.Fatal(err)
+		}
+	}
+}
+
 // ThreatSpec TMv0.1 for caDeleteCmd
 
 func caDeleteCmd(cmd *cli.Cmd) {
@@ -217,6 +620,7 @@ func caDeleteCmd(cmd *cli.Cmd) {
 	params.Name = cmd.StringArg("NAME", "", "name of CA")
 
 	params.ConfirmDelete = cmd.StringOpt("confirm-delete", "", "reason for deleting CA")
+	approvalId := cmd.StringOpt("approval", "", "id of an approved request for this deletion")
 
 	cmd.Action = func() {
 		app := NewAdminApp()
@@ -227,8 +631,15 @@ func caDeleteCmd(cmd *cli.Cmd) {
 			app.Fatal(err)
 		}
 
+		action := fmt.Sprintf(ActionCADelete, *params.Name)
+		app.Authorize(PermManageCA, caTags(app, *params.Name))
+		app.Approve(action, *approvalId)
+
+		app.Audit("ca delete", *params.Name, "", *params.ConfirmDelete)
+
 		if err := cont.Delete(params); err != nil {
 			app.Fatal(err)
 		}
+		app.CompleteApproval(action, *approvalId)
 	}
 }
@@ -2,9 +2,13 @@
 package main
 
 import (
+	cx509 "crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
 	"github.com/jawher/mow.cli"
 	"github.com/pki-io/controller"
+	"github.com/pki-io/core/x509"
+	"time"
 )
 
 func certCmd(cmd *cli.Cmd) {
@@ -12,9 +16,151 @@ func certCmd(cmd *cli.Cmd) {
 	cmd.Command("list", "List certificates", certListCmd)
 	cmd.Command("show", "Show a certificate", certShowCmd)
 	cmd.Command("update", "Update a certificate", certUpdateCmd)
+	cmd.Command("renew", "Renew a certificate", certRenewCmd)
+	cmd.Command("revoke", "Revoke a certificate", certRevokeCmd)
 	cmd.Command("delete", "Delete a certificate", certDeleteCmd)
 }
 
+func certStatus(revocation *Revocation) string {
+	if revocation != nil {
+		return "revoked"
+	}
+	return "valid"
+}
+
+// certRevocation returns the revocation of the current version of a
+// certificate, or nil if it isn't revoked.
+func certRevocation(revocations *Revocations, cert *x509.Certificate) *Revocation {
+	c, err := parseCertificate(cert.Data.Body.Certificate)
+	if err != nil {
+		return nil
+	}
+	return revocations.Find(cert.Id(), c.SerialNumber.String())
+}
+
+// certIssuer returns the org CA that issued a certificate, or nil if it
+// wasn't issued by one.
+func certIssuer(app *AdminApp, cert *cx509.Certificate) *x509.CA {
+	cont, err := controller.NewCA(app.env)
+	if err != nil {
+		app.Fatal(err)
+	}
+
+	cas, err := cont.List(controller.NewCAParams())
+	if err != nil {
+		app.Fatal(err)
+	}
+
+	var candidates []*cx509.Certificate
+	for _, ca := range cas {
+		// Unparsable CAs can't be issuers so just skip them
+		c, _ := parseCertificate(ca.Data.Body.Certificate)
+		candidates = append(candidates, c)
+	}
+
+	if i := findIssuer(cert, candidates); i >= 0 {
+		return cas[i]
+	}
+	return nil
+}
+
+// historyDoc is the org store document holding the previous versions of
+// renewed certificates.
+const historyDoc = "history"
+
+// CertHistory maps certificate ids to the PEM encoded previous versions of
+// the certificates, oldest first.
+type CertHistory map[string][]string
+
+// certHistoryOutput describes the previous versions of a renewed certificate,
+// oldest first.
+func certHistoryOutput(app *AdminApp, cert *x509.Certificate) []*OutputDoc {
+	history := make(CertHistory)
+	if _, err := app.Store().Load(historyDoc, &history); err != nil {
+		app.Fatal(err)
+	}
+
+	docs := []*OutputDoc{}
+	for i, certPEM := range history[cert.Id()] {
+		c, err := parseCertificate(certPEM)
+		if err != nil {
+			logger.Warnf("could not parse version %d of certificate '%s': %s", i+1, cert.Name(), err)
+			continue
+		}
+		doc := NewOutputDoc().Add("version", "Version", i+1)
+		addValidity(doc, c)
+		docs = append(docs, doc.AddBlock("certificate", "Certificate", certPEM))
+	}
+	return docs
+}
+
+// certCAChainPEM returns the PEM encoded chain of org CAs that issued a
+// certificate, falling back to the given CA certificate if the chain can't be
+// found.
+func certCAChainPEM(app *AdminApp, certPEM, fallback string) string {
+	cont, err := controller.NewCA(app.env)
+	if err != nil {
+		app.Fatal(err)
+	}
+
+	cas, err := cont.List(controller.NewCAParams())
+	if err != nil {
+		app.Fatal(err)
+	}
+
+	var candidates []string
+	for _, ca := range cas {
+		candidates = append(candidates, ca.Data.Body.Certificate)
+	}
+
+	indexes, err := issuerChain(certPEM, candidates)
+	if err != nil || len(indexes) == 0 {
+		return fallback
+	}
+
+	var chain []*x509.CA
+	for _, i := range indexes {
+		chain = append(chain, cas[i])
+	}
+
+	return caChainPEM(chain) + "\n"
+}
+
+// certOutput describes a certificate, which has been revoked if revocation
+// isn't nil.
+func certOutput(cert *x509.Certificate, revocation *Revocation, private bool) *OutputDoc {
+	doc := NewOutputDoc().
+		Add("id", "ID", cert.Id()).
+		Add("name", "Name", cert.Name()).
+		Add("tags", "Tags", cert.Data.Body.Tags).
+		Add("key_type", "Key type", cert.Data.Body.KeyType).
+		Add("status", "Status", certStatus(revocation))
+
+	if c, err := parseCertificate(cert.Data.Body.Certificate); err != nil {
+		logger.Warnf("could not parse certificate '%s': %s", cert.Name(), err)
+	} else {
+		addValidity(doc, c)
+		doc.Add("extensions", "Extensions", extensionsOutput(c))
+	}
+
+	if revocation != nil {
+		doc.Add("revoked_at", "Revoked at", formatTime(revocation.RevokedAt))
+		doc.Add("revocation_reason", "Revocation reason", revocation.Reason)
+	}
+
+	doc.AddBlock("certificate", "Certificate", cert.Data.Body.Certificate)
+
+	if cert.Data.Body.CACertificate != "" {
+		doc.AddBlock("ca_certificate", "CA certificate", cert.Data.Body.CACertificate)
+	}
+
+	if private {
+		doc.AddBlock("private_key", "Private key", cert.Data.Body.PrivateKey)
+	}
+
+	return doc
+}
+
 func certNewCmd(cmd *cli.Cmd) {
 	cmd.Spec = "NAME [OPTIONS]"
 
@@ -22,70 +168,244 @@ func certNewCmd(cmd *cli.Cmd) {
 	params.Name = cmd.StringArg("NAME", "", "name of certificate")
 
 	params.Tags = cmd.StringOpt("tags", "NAME", "comma separated list of tags")
-	params.StandaloneFile = cmd.StringOpt("standalone", "", "certificate isn't managed by the org but is exported as a tar.gz")
+	params.StandaloneFile = cmd.StringOpt("standalone", "", "certificate isn't managed by the org but is exported to a file")
+	exportParams := NewExportParams()
+	exportParams.Format = cmd.StringOpt("export-format", ExportTGZ, "export format (tgz, p12, jks, pem-bundle or der)")
+	exportParams.Force = cmd.BoolOpt("force", false, "overwrite an existing export file")
+	exportParams.Dir = cmd.StringOpt("export-dir", "", "certificate isn't managed by the org but is exported into a directory")
+	exportParams.Fullchain = cmd.BoolOpt("fullchain", false, "also write the certificate and CA chain to fullchain.pem with --export-dir")
+	exportParams.Names = cmd.StringsOpt("export-name", nil, "file name template for --export-dir as TYPE=TEMPLATE, e.g. key={name}.key (repeatable)")
+	exportParams.Passphrase.Env = cmd.StringOpt("passphrase-env", "", "environment variable holding the p12/jks export passphrase")
+	exportParams.Passphrase.File = cmd.StringOpt("passphrase-file", "", "file holding the p12/jks export passphrase")
 	params.CertFile = cmd.StringOpt("cert", "", "certificate PEM file")
 	params.KeyFile = cmd.StringOpt("key", "", "key PEM file")
-	params.Expiry = cmd.IntOpt("expiry", 365, "expiry period in days")
+	params.Expiry = cmd.IntOpt("expiry", profile.Int(ProfileCertExpiry, 365), "expiry period in days")
 	params.Ca = cmd.StringOpt("ca", "", "name of the signing CA (self-signed by default)")
-	params.KeyType = cmd.StringOpt("key-type", "ec", "Key type (ec or rsa)")
-	params.DnLocality = cmd.StringOpt("dn-l", "", "Locality for DN")
-	params.DnState = cmd.StringOpt("dn-st", "", "State/province for DN")
-	params.DnOrg = cmd.StringOpt("dn-o", "", "Organization for DN")
-	params.DnOrgUnit = cmd.StringOpt("dn-ou", "", "Organizational unit for DN")
-	params.DnCountry = cmd.StringOpt("dn-c", "", "Country for DN")
-	params.DnStreet = cmd.StringOpt("dn-street", "", "Street for DN")
-	params.DnPostal = cmd.StringOpt("dn-postal", "", "PostalCode for DN")
+	params.KeyType = cmd.StringOpt("key-type", profile.String(ProfileKeyType, "ec"), "Key type (ec or rsa)")
+	params.DnLocality = cmd.StringOpt("dn-l", profile.String(ProfileDnLocality, ""), "Locality for DN")
+	params.DnState = cmd.StringOpt("dn-st", profile.String(ProfileDnState, ""), "State/province for DN")
+	params.DnOrg = cmd.StringOpt("dn-o", profile.String(ProfileDnOrg, ""), "Organization for DN")
+	params.DnOrgUnit = cmd.StringOpt("dn-ou", profile.String(ProfileDnOrgUnit, ""), "Organizational unit for DN")
+	params.DnCountry = cmd.StringOpt("dn-c", profile.String(ProfileDnCountry, ""), "Country for DN")
+	params.DnStreet = cmd.StringOpt("dn-street", profile.String(ProfileDnStreet, ""), "Street for DN")
+	params.DnPostal = cmd.StringOpt("dn-postal", profile.String(ProfileDnPostal, ""), "PostalCode for DN")
+	extParams := NewExtensionParams(cmd)
 
 	cmd.Action = func() {
 		app := NewAdminApp()
 		logger.Info("creating new certificate")
 
+		if *params.Ca != "" {
+			app.Authorize(PermIssue, caTags(app, *params.Ca))
+		} else {
+			app.Authorize(PermIssue, tagsOf(entityTags(*params.Tags, *params.Name)))
+		}
+
+		if err := exportParams.Check(*params.StandaloneFile); err != nil {
+			app.Fail(err)
+		}
+
+		ext := new(Extensions)
+		if err := extParams.Parse(ext); err != nil {
+			app.Fail(err)
+		}
+		if extParams.Given() && (*params.CertFile != "" || *params.KeyFile != "") {
+			app.Fail(fmt.Errorf("extension options can't be used with --cert and --key"))
+		}
+		if *exportParams.Dir != "" && (*params.CertFile != "" || *params.KeyFile != "") {
+			app.Fail(fmt.Errorf("--export-dir can't be used with --cert and --key"))
+		}
+
 		cont, err := controller.NewCertificate(app.env)
 		if err != nil {
 			app.Fatal(err)
 		}
 
-		cert, ca, err := cont.New(params)
+		if !extParams.Given() && *exportParams.Dir == "" {
+			app.Audit("cert new", *params.Name, "", "")
+
+			cert, ca, err := cont.New(params)
+			if err != nil {
+				app.Fatal(err)
+			}
+
+			if cert == nil {
+				return
+			}
+
+			if *params.StandaloneFile == "" {
+				app.RenderResult(certOutput(cert, nil, false), NewOutputDoc().
+					Add("id", "Id", cert.Id()).
+					Add("name", "Name", cert.Name()))
+			} else {
+				caCert := ""
+				if ca != nil {
+					caCert = ca.Data.Body.Certificate
+				}
+				exportNewCert(app, cert.Data.Body.Name, cert.Data.Body.Certificate, cert.Data.Body.PrivateKey, caCert, *params.StandaloneFile, "", exportParams)
+			}
+			return
+		}
+
+		// The controller can't add extensions or export into a directory, so
+		// the certificate is issued here and either exported or imported
+		// into the org
+		ca := showSigningCA(app, *params.Ca)
+		template := &cx509.Certificate{Subject: certSubject(*params.Name, ca, params.DnCountry, params.DnState, params.DnLocality, params.DnOrg, params.DnOrgUnit, params.DnStreet, params.DnPostal)}
+		ext.Apply(template)
+
+		caCert, caKey := "", ""
+		if ca != nil {
+			caCert, caKey = ca.Data.Body.Certificate, ca.Data.Body.PrivateKey
+		}
+
+		certPEM, keyPEM, err := issueCertificate(template, *params.Expiry, *params.KeyType, "", caCert, caKey)
 		if err != nil {
-			app.Fatal(err)
+			app.Fail(err)
 		}
 
-		if cert == nil {
+		app.Audit("cert new", *params.Name, "", "")
+
+		if *params.StandaloneFile != "" || *exportParams.Dir != "" {
+			exportNewCert(app, *params.Name, certPEM, keyPEM, caCert, *params.StandaloneFile, *exportParams.Dir, exportParams)
 			return
 		}
 
-		if *params.StandaloneFile == "" {
-			table := app.NewTable()
+		cert := importCert(app, cont, params, certPEM, keyPEM)
+		app.RenderResult(certOutput(cert, nil, false), NewOutputDoc().
+			Add("id", "Id", cert.Id()).
+			Add("name", "Name", cert.Name()))
+	}
+}
 
-			certData := [][]string{
-				[]string{"Id", cert.Id()},
-				[]string{"Name", cert.Name()},
-			}
-			table.AppendBulk(certData)
+// showSigningCA returns the named CA with its private key, or nil for a
+// self-signed certificate if name is empty.
+func showSigningCA(app *AdminApp, name string) *x509.CA {
+	if name == "" {
+		return nil
+	}
 
-			app.RenderTable(table)
-		} else {
-			var files []ExportFile
-			certFile := fmt.Sprintf("%s-cert.pem", cert.Data.Body.Name)
-			keyFile := fmt.Sprintf("%s-key.pem", cert.Data.Body.Name)
-			caFile := fmt.Sprintf("%s-cacert.pem", cert.Data.Body.Name)
+	cont, err := controller.NewCA(app.env)
+	if err != nil {
+		app.Fatal(err)
+	}
 
-			if ca != nil {
-				files = append(files, ExportFile{Name: caFile, Mode: 0644, Content: []byte(ca.Data.Body.Certificate)})
-			}
+	private := true
+	params := controller.NewCAParams()
+	params.Name = &name
+	params.Private = &private
 
-			files = append(files, ExportFile{Name: certFile, Mode: 0644, Content: []byte(cert.Data.Body.Certificate)})
-			files = append(files, ExportFile{Name: keyFile, Mode: 0600, Content: []byte(cert.Data.Body.PrivateKey)})
+	ca, err := cont.Show(params)
+	if err != nil {
+		app.Fatal(err)
+	}
+	if ca == nil {
+		app.Fail(fmt.Errorf("CA '%s' not found", name))
+	}
+	return ca
+}
+
+// certSubject returns the subject of a new certificate from the DN options,
+// with any DN scope of the signing CA taking precedence.
+func certSubject(name string, ca *x509.CA, country, province, locality, org, orgUnit, street, postal *string) pkix.Name {
+	return scopeSubject(subjectName(name, *country, *province, *locality, *org, *orgUnit, *street, *postal), ca)
+}
+
+// scopeSubject returns subject with the fields in the signing CA's DN scope
+// replaced by the scope's values.
+func scopeSubject(subject pkix.Name, ca *x509.CA) pkix.Name {
+	if ca == nil {
+		return subject
+	}
+
+	scope := ca.Data.Body.DNScope
+	for _, f := range []struct {
+		scope string
+		field *[]string
+	}{
+		{scope.Country, &subject.Country},
+		{scope.Province, &subject.Province},
+		{scope.Locality, &subject.Locality},
+		{scope.Organization, &subject.Organization},
+		{scope.OrganizationalUnit, &subject.OrganizationalUnit},
+		{scope.StreetAddress, &subject.StreetAddress},
+		{scope.PostalCode, &subject.PostalCode},
+	} {
+		if f.scope != "" {
+			*f.field = []string{f.scope}
+		}
+	}
+	return subject
+}
+
+// importCert adds a certificate issued by the CLI to the org. keyPEM is
+// empty for a certificate signed from a CSR that was imported without its
+// key, and the certificate is then imported on its own.
+func importCert(app *AdminApp, cont *controller.CertificateController, params *controller.CertificateParams, certPEM, keyPEM string) *x509.Certificate {
+	contents := []string{certPEM}
+	if keyPEM != "" {
+		contents = append(contents, keyPEM)
+	}
 
-			logger.Debugf("Exporting to '%s'", *params.StandaloneFile)
-			Export(files, *params.StandaloneFile)
+	var cert *x509.Certificate
+	err := withTempFiles(contents, func(paths []string) error {
+		noCA, keyFile := "", ""
+		if len(paths) > 1 {
+			keyFile = paths[1]
 		}
+		params.Ca = &noCA
+		params.CertFile = &paths[0]
+		params.KeyFile = &keyFile
+
+		var err error
+		cert, _, err = cont.New(params)
+		return err
+	})
+	if err != nil {
+		app.Fatal(err)
+	}
+	if cert == nil {
+		app.Fatal(fmt.Errorf("certificate '%s' wasn't imported", *params.Name))
+	}
+	return cert
+}
+
+// exportNewCert exports a standalone certificate, its key and the chain of
+// the CA that issued it, if any, into dir if it is set or else to outFile.
+func exportNewCert(app *AdminApp, name, certPEM, keyPEM, caCert, outFile, dir string, exportParams *ExportParams) {
+	var files []ExportFile
+	certFile := fmt.Sprintf("%s-cert.pem", name)
+	keyFile := fmt.Sprintf("%s-key.pem", name)
+	caFile := fmt.Sprintf("%s-cacert.pem", name)
+
+	if caCert != "" {
+		caChain := certCAChainPEM(app, certPEM, caCert)
+		files = append(files, NewExportFile(caFile, ExportFileCACert, 0644, []byte(caChain)))
+	}
+
+	files = append(files, NewExportFile(certFile, ExportFileCert, 0644, []byte(certPEM)))
+	files = append(files, NewExportFile(keyFile, ExportFileKey, 0600, []byte(keyPEM)))
+
+	*exportParams.Alias = name
+
+	logger.Debugf("Exporting certificate '%s'", name)
+	var err error
+	if dir != "" {
+		err = ExportDir(files, name, dir, exportParams)
+	} else {
+		err = ExportFormat(files, outFile, exportParams)
+	}
+	if err != nil {
+		app.Fatal(err)
 	}
 }
 
 func certListCmd(cmd *cli.Cmd) {
 	params := controller.NewCertificateParams()
 
+	expiringWithin := cmd.StringOpt("expiring-within", "", "only list certificates expiring within a period, e.g. 30d")
+	expired := cmd.BoolOpt("expired", false, "only list expired certificates")
+
 	cmd.Action = func() {
 		app := NewAdminApp()
 		logger.Info("listing certificates")
@@ -100,14 +420,25 @@ func certListCmd(cmd *cli.Cmd) {
 			app.Fatal(err)
 		}
 
-		table := app.NewTable()
-		table.SetHeader([]string{"Name", "Id"})
+		filter, err := NewExpiryFilter(*expiringWithin, *expired)
+		if err != nil {
+			app.Fail(err)
+		}
+
+		revocations := loadRevocations(app)
 
+		var docs []*OutputDoc
 		for _, cert := range certs {
-			table.Append([]string{cert.Name(), cert.Id()})
+			if filter.Active() {
+				c, err := parseCertificate(cert.Data.Body.Certificate)
+				if err != nil || !filter.Match(c.NotAfter) {
+					continue
+				}
+			}
+			docs = append(docs, certOutput(cert, certRevocation(revocations, cert), false))
 		}
 
-		app.RenderTable(table)
+		app.RenderList(docs, "Name", "ID", "Status", "Serial", "Issuer", "Not before", "Not after")
 	}
 }
 
@@ -117,13 +448,31 @@ func certShowCmd(cmd *cli.Cmd) {
 	params := controller.NewCertificateParams()
 	params.Name = cmd.StringArg("NAME", "", "name of certificate")
 
-	params.Export = cmd.StringOpt("export", "", "tar.gz export to file")
+	params.Export = cmd.StringOpt("export", "", "export to file")
 	params.Private = cmd.BoolOpt("private", false, "show/export private data")
+	history := cmd.BoolOpt("history", false, "show previous versions of a renewed certificate")
+	exportParams := NewExportParams()
+	exportParams.Format = cmd.StringOpt("export-format", ExportTGZ, "export format (tgz, p12, jks, pem-bundle or der)")
+	exportParams.Alias = cmd.StringOpt("alias", "", "jks keystore alias (defaults to the certificate name)")
+	exportParams.Force = cmd.BoolOpt("force", false, "overwrite an existing export file")
+	exportParams.Dir = cmd.StringOpt("export-dir", "", "export files into a directory")
+	exportParams.Fullchain = cmd.BoolOpt("fullchain", false, "also write the certificate and CA chain to fullchain.pem with --export-dir")
+	exportParams.Names = cmd.StringsOpt("export-name", nil, "file name template for --export-dir as TYPE=TEMPLATE, e.g. key={name}.key (repeatable)")
+	exportParams.Passphrase.Env = cmd.StringOpt("passphrase-env", "", "environment variable holding the p12/jks export passphrase")
+	exportParams.Passphrase.File = cmd.StringOpt("passphrase-file", "", "file holding the p12/jks export passphrase")
 
 	cmd.Action = func() {
 		app := NewAdminApp()
 		logger.Info("showing certificate")
 
+		if *params.Private {
+			app.Authorize(PermReadPrivate, certTags(app, *params.Name))
+		}
+
+		if err := exportParams.Check(*params.Export); err != nil {
+			app.Fail(err)
+		}
+
 		cont, err := controller.NewCertificate(app.env)
 		if err != nil {
 			app.Fatal(err)
@@ -138,40 +487,39 @@ func certShowCmd(cmd *cli.Cmd) {
 			return
 		}
 
-		if *params.Export == "" {
-			table := app.NewTable()
-
-			certData := [][]string{
-				[]string{"ID", cert.Id()},
-				[]string{"Name", cert.Name()},
-				[]string{"Key type", cert.Data.Body.KeyType},
-			}
-
-			table.AppendBulk(certData)
-
-			app.RenderTable(table)
-			fmt.Println("")
-			fmt.Printf("Certificate:\n%s\n", cert.Data.Body.Certificate)
+		if *params.Private {
+			app.Audit("cert show --private", *params.Name, cert.Id(), "")
+		}
 
-			if *params.Private {
-				fmt.Printf("Private key:\n%s\n", cert.Data.Body.PrivateKey)
+		if *params.Export == "" && *exportParams.Dir == "" {
+			doc := certOutput(cert, certRevocation(loadRevocations(app), cert), *params.Private)
+			if *history {
+				doc.Add("history", "History", certHistoryOutput(app, cert))
 			}
+			app.RenderItem(doc)
 		} else {
 			var files []ExportFile
 			certFile := fmt.Sprintf("%s-cert.pem", cert.Data.Body.Name)
 			keyFile := fmt.Sprintf("%s-key.pem", cert.Data.Body.Name)
-			files = append(files, ExportFile{Name: certFile, Mode: 0644, Content: []byte(cert.Data.Body.Certificate)})
+			files = append(files, NewExportFile(certFile, ExportFileCert, 0644, []byte(cert.Data.Body.Certificate)))
 
 			if cert.Data.Body.CACertificate != "" {
 				caFile := fmt.Sprintf("%s-cacert.pem", cert.Data.Body.Name)
-				files = append(files, ExportFile{Name: caFile, Mode: 0644, Content: []byte(cert.Data.Body.CACertificate)})
+				caChain := certCAChainPEM(app, cert.Data.Body.Certificate, cert.Data.Body.CACertificate)
+				files = append(files, NewExportFile(caFile, ExportFileCACert, 0644, []byte(caChain)))
 			}
 
 			if *params.Private {
-				files = append(files, ExportFile{Name: keyFile, Mode: 0600, Content: []byte(cert.Data.Body.PrivateKey)})
+				files = append(files, NewExportFile(keyFile, ExportFileKey, 0600, []byte(cert.Data.Body.PrivateKey)))
+			}
+			if *exportParams.Alias == "" {
+				*exportParams.Alias = cert.Data.Body.Name
+			}
+
+			logger.Debugf("exporting certificate '%s'", cert.Data.Body.Name)
+			if err := Export(files, cert.Data.Body.Name, *params.Export, exportParams); err != nil {
+				app.Fatal(err)
 			}
-			logger.Debugf("exporting to '%s'", *params.Export)
-			Export(files, *params.Export)
 		}
 
 	}
@@ -191,17 +539,194 @@ func certUpdateCmd(cmd *cli.Cmd) {
 		app := NewAdminApp()
 		logger.Info("updating certificate")
 
+		app.Authorize(PermManageCert, certTags(app, *params.Name))
+
 		cont, err := controller.NewCertificate(app.env)
 		if err != nil {
 			app.Fatal(err)
 		}
 
+		app.Audit("cert update", *params.Name, "", "")
+
 		if err := cont.Update(params); err != nil {
 			app.Fatal(err)
 		}
 	}
 }
 
+func certRenewCmd(cmd *cli.Cmd) {
+	cmd.Spec = "NAME [OPTIONS]"
+
+	params := controller.NewCertificateParams()
+	params.Name = cmd.StringArg("NAME", "", "name of certificate")
+
+	expiry := cmd.IntOpt("expiry", 0, "expiry period in days (same as the current certificate by default)")
+	rekey := cmd.BoolOpt("rekey", false, "generate a new key instead of reusing the existing one")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("renewing certificate")
+
+		app.Authorize(PermIssue, certTags(app, *params.Name))
+
+		if *expiry < 0 {
+			app.Fail(fmt.Errorf("invalid expiry period: %d days", *expiry))
+		}
+
+		cont, err := controller.NewCertificate(app.env)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		private := true
+		params.Private = &private
+		cert, err := cont.Show(params)
+		if err != nil {
+			app.Fatal(err)
+		}
+		if cert == nil {
+			app.Fail(fmt.Errorf("certificate '%s' not found", *params.Name))
+		}
+
+		old, err := parseCertificate(cert.Data.Body.Certificate)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		// A revoked certificate has to be replaced with cert new, so that it
+		// doesn't come back under its old name and tags
+		if certRevocation(loadRevocations(app), cert) != nil {
+			app.Fail(fmt.Errorf("certificate '%s' is revoked and can't be renewed", *params.Name))
+		}
+
+		// The controller can only issue new certificates, so the renewal is
+		// signed here by the CA that issued the current one
+		issuer := certIssuer(app, old)
+		if issuer == nil {
+			app.Fail(fmt.Errorf("certificate '%s' wasn't issued by an org CA, use cert update to replace it", *params.Name))
+		}
+		issuer = showSigningCA(app, issuer.Name())
+
+		days := *expiry
+		if days == 0 {
+			days = int((old.NotAfter.Sub(old.NotBefore) + 12*time.Hour) / (24 * time.Hour))
+		}
+
+		keyPEM := cert.Data.Body.PrivateKey
+		if *rekey {
+			keyPEM = ""
+		}
+
+		template := &cx509.Certificate{Subject: old.Subject}
+		CertificateExtensions(old).Apply(template)
+
+		certPEM, keyPEM, err := issueCertificate(template, days, cert.Data.Body.KeyType, keyPEM, issuer.Data.Body.Certificate, issuer.Data.Body.PrivateKey)
+		if err != nil {
+			app.Fail(err)
+		}
+
+		app.Audit("cert renew", *params.Name, cert.Id(), "")
+
+		// The update replaces the current certificate, so it is kept first
+		history := make(CertHistory)
+		err = app.Store().Update(historyDoc, &history, func() error {
+			history[cert.Id()] = append(history[cert.Id()], cert.Data.Body.Certificate)
+			return nil
+		})
+		if err != nil {
+			app.Fatal(fmt.Errorf("could not keep the current version of certificate '%s': %s", *params.Name, err))
+		}
+
+		err = withTempFiles([]string{certPEM, keyPEM}, func(paths []string) error {
+			keepTags := ""
+			params.Tags = &keepTags
+			params.CertFile = &paths[0]
+			params.KeyFile = &paths[1]
+			return cont.Update(params)
+		})
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		renewed, err := cont.Show(params)
+		if err != nil {
+			app.Fatal(err)
+		}
+		app.RenderItem(certOutput(renewed, nil, false))
+	}
+}
+
+func certRevokeCmd(cmd *cli.Cmd) {
+	cmd.Spec = "NAME [OPTIONS]"
+
+	params := controller.NewCertificateParams()
+	params.Name = cmd.StringArg("NAME", "", "name of certificate")
+
+	reason := cmd.StringOpt("reason", "unspecified", "revocation reason (unspecified, keyCompromise, caCompromise, affiliationChanged, superseded, cessationOfOperation, certificateHold, privilegeWithdrawn or aACompromise)")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("revoking certificate")
+
+		app.Authorize(PermManageCert, certTags(app, *params.Name))
+
+		if err := checkRevocationReason(*reason); err != nil {
+			app.Fail(err)
+		}
+
+		cont, err := controller.NewCertificate(app.env)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		private := false
+		params.Private = &private
+
+		cert, err := cont.Show(params)
+		if err != nil {
+			app.Fatal(err)
+		}
+		if cert == nil {
+			app.Fail(fmt.Errorf("certificate '%s' not found", *params.Name))
+		}
+
+		c, err := parseCertificate(cert.Data.Body.Certificate)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		ca := certIssuer(app, c)
+		if ca == nil {
+			app.Fail(fmt.Errorf("certificate '%s' wasn't issued by an org CA, so there is no CRL to revoke it in", *params.Name))
+		}
+
+		revocation := &Revocation{
+			CertId:    cert.Id(),
+			Name:      cert.Name(),
+			Serial:    c.SerialNumber.String(),
+			CAId:      ca.Id(),
+			RevokedAt: time.Now().Unix(),
+			Reason:    *reason,
+		}
+
+		app.Audit("cert revoke", *params.Name, cert.Id(), *reason)
+
+		revocations := new(Revocations)
+		err = app.Store().Update(revocationsDoc, revocations, func() error {
+			if revocations.FindSerial(ca.Id(), revocation.Serial) != nil {
+				return fmt.Errorf("certificate '%s' is already revoked", *params.Name)
+			}
+			revocations.Certs = append(revocations.Certs, revocation)
+			return nil
+		})
+		if err != nil {
+			app.Fail(err)
+		}
+
+		app.RenderItem(certOutput(cert, revocation, false))
+	}
+}
+
 func certDeleteCmd(cmd *cli.Cmd) {
 	cmd.Spec = "NAME [OPTIONS]"
 
@@ -214,11 +739,15 @@ func certDeleteCmd(cmd *cli.Cmd) {
 		app := NewAdminApp()
 		logger.Info("deleting certificate")
 
+		app.Authorize(PermManageCert, certTags(app, *params.Name))
+
 		cont, err := controller.NewCertificate(app.env)
 		if err != nil {
 			app.Fatal(err)
 		}
 
+		app.Audit("cert delete", *params.Name, "", *params.ConfirmDelete)
+
 		if err := cont.Delete(params); err != nil {
 			app.Fatal(err)
 		}
@@ -0,0 +1,52 @@
+// ThreatSpec package main
+package main
+
+import (
+	"fmt"
+	"github.com/jawher/mow.cli"
+	"strings"
+)
+
+func completionCmd(cmd *cli.Cmd) {
+	cmd.Command("bash", "Print a bash completion script", completionBashCmd)
+	cmd.Command("zsh", "Print a zsh completion script", completionZshCmd)
+	cmd.Command("fish", "Print a fish completion script", completionFishCmd)
+	cmd.Command("names", "List entity names for completion scripts", completionNamesCmd)
+}
+
+func completionBashCmd(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		fmt.Print(BashCompletion())
+	}
+}
+
+func completionZshCmd(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		fmt.Print(ZshCompletion())
+	}
+}
+
+func completionFishCmd(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		fmt.Print(FishCompletion())
+	}
+}
+
+func completionNamesCmd(cmd *cli.Cmd) {
+	cmd.Spec = "TYPE"
+
+	kind := cmd.StringArg("TYPE", "", "entity type (ca, cert, csr, node, admin, pairing-key, invite, approval, org or profile)")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+
+		names, err := completionNames(app, *kind)
+		if err != nil {
+			app.Fail(err)
+		}
+
+		if len(names) > 0 {
+			fmt.Println(strings.Join(names, "\n"))
+		}
+	}
+}
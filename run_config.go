@@ -0,0 +1,115 @@
+// ThreatSpec package main
+package main
+
+import (
+	"fmt"
+	"github.com/jawher/mow.cli"
+)
+
+func configCmd(cmd *cli.Cmd) {
+	cmd.Command("list", "List config profiles and their settings", configListCmd)
+	cmd.Command("get", "Show a setting of the current profile", configGetCmd)
+	cmd.Command("set", "Change a setting of the current profile", configSetCmd)
+}
+
+func configListCmd(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Infof("listing config profiles in '%s'", userConfigPath)
+
+		var docs []*OutputDoc
+		for _, name := range userConfig.ProfileNames() {
+			settings := userConfig.Profiles[name]
+			for _, setting := range profileSettings {
+				if _, ok := settings[setting.Name]; ok {
+					docs = append(docs, NewOutputDoc().
+						Add("profile", "Profile", name).
+						Add("current", "Current", name == profileName).
+						Add("key", "Key", setting.Name).
+						Add("value", "Value", settings.String(setting.Name, "")))
+				}
+			}
+		}
+
+		app.RenderList(docs, "Profile", "Current", "Key", "Value")
+	}
+}
+
+func settingOutput(profile, key string, value interface{}) *OutputDoc {
+	return NewOutputDoc().
+		Add("profile", "Profile", profile).
+		Add("key", "Key", key).
+		Add("value", "Value", fmt.Sprint(value))
+}
+
+func configGetCmd(cmd *cli.Cmd) {
+	cmd.Spec = "KEY"
+
+	key := cmd.StringArg("KEY", "", "setting to show, or default_profile")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+
+		if *key == ConfigDefaultProfile {
+			if userConfig.DefaultProfile == "" {
+				app.Fail(fmt.Errorf("%s isn't set", ConfigDefaultProfile))
+			}
+			app.RenderItem(settingOutput("", *key, userConfig.DefaultProfile))
+			return
+		}
+
+		if _, err := findProfileSetting(*key); err != nil {
+			app.Fail(err)
+		}
+
+		value, ok := profile[*key]
+		if !ok {
+			app.Fail(fmt.Errorf("%s isn't set in profile '%s'", *key, profileName))
+		}
+		app.RenderItem(settingOutput(profileName, *key, value))
+	}
+}
+
+func configSetCmd(cmd *cli.Cmd) {
+	cmd.Spec = "KEY VALUE"
+
+	key := cmd.StringArg("KEY", "", "setting to change, or default_profile")
+	value := cmd.StringArg("VALUE", "", "new value")
+	create := cmd.BoolOpt("new", false, "create the profile if it doesn't exist")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+
+		// Invalid settings are dropped when the file is saved, but a file
+		// that doesn't parse would be lost entirely
+		if configErr != nil {
+			if config, _ := LoadConfig(userConfigPath); config == nil {
+				app.Fail(fmt.Errorf("%s, it has to be fixed by hand", configErr))
+			}
+		}
+
+		if *key == ConfigDefaultProfile {
+			logger.Infof("setting the default profile to '%s'", *value)
+			userConfig.DefaultProfile = *value
+		} else {
+			logger.Infof("setting %s in profile '%s'", *key, profileName)
+			// A mistyped --profile shouldn't quietly become a new profile,
+			// so only the default profile is made without asking
+			settings := userConfig.Profiles[profileName]
+			if settings == nil {
+				if !*create && profileName != "default" {
+					app.Fail(fmt.Errorf("profile '%s' isn't in '%s', use --new to create it", profileName, userConfigPath))
+				}
+				settings = make(Profile)
+			}
+			if err := settings.Set(*key, *value); err != nil {
+				app.Fail(err)
+			}
+			userConfig.Profiles[profileName] = settings
+		}
+
+		if err := userConfig.Save(userConfigPath); err != nil {
+			app.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// ThreatSpec package main
+package main
+
+import (
+	"fmt"
+	"github.com/jawher/mow.cli"
+	"github.com/pki-io/controller"
+	"github.com/pki-io/core/document"
+	"github.com/pki-io/core/entity"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+func containerCmd(cmd *cli.Cmd) {
+	cmd.Command("encrypt", "Encrypt and sign a document for the org", containerEncryptCmd)
+	cmd.Command("decrypt", "Verify and decrypt an org document container", containerDecryptCmd)
+	cmd.Command("verify", "Verify the signature of an org document container", containerVerifyCmd)
+	cmd.Command("inspect", "Show the signer and recipients of a container without decrypting it", containerInspectCmd)
+}
+
+// readInput reads a file, or stdin if name is "-".
+func readInput(name string) ([]byte, error) {
+	if name == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(name)
+}
+
+func readContainer(name string) (*document.Container, error) {
+	content, err := readInput(name)
+	if err != nil {
+		return nil, err
+	}
+
+	container, err := document.NewContainer(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("'%s' isn't a document container: %s", name, err)
+	}
+	return container, nil
+}
+
+// orgEntity returns the org with its keys, which encrypt, sign and verify
+// containers.
+func orgEntity(app *AdminApp) *entity.Entity {
+	return app.Store().org
+}
+
+// entityNames maps the ids of the org, its admins and its nodes to their names
+// so that container metadata can be shown by name. Entities that can't be
+// listed are left out, so they are shown by id.
+func entityNames(app *AdminApp) map[string]string {
+	names := make(map[string]string)
+
+	org := orgEntity(app)
+	names[org.Id()] = fmt.Sprintf("org %s", org.Name())
+
+	if err := adminEntityNames(app, names); err != nil {
+		logger.Warnf("could not list admins, showing their ids: %s", err)
+	}
+	if err := nodeEntityNames(app, names); err != nil {
+		logger.Warnf("could not list nodes, showing their ids: %s", err)
+	}
+
+	return names
+}
+
+func adminEntityNames(app *AdminApp, names map[string]string) error {
+	cont, err := controller.NewAdmin(app.env)
+	if err != nil {
+		return err
+	}
+	admins, err := cont.List(controller.NewAdminParams())
+	if err != nil {
+		return err
+	}
+	for _, admin := range admins {
+		names[admin.Id()] = fmt.Sprintf("admin %s", admin.Name())
+	}
+	return nil
+}
+
+func nodeEntityNames(app *AdminApp, names map[string]string) error {
+	cont, err := controller.NewNode(app.env)
+	if err != nil {
+		return err
+	}
+	nodes, err := cont.List(controller.NewNodeParams())
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		names[node.Id()] = fmt.Sprintf("node %s", node.Name())
+	}
+	return nil
+}
+
+func describeEntity(names map[string]string, id string) string {
+	if name, ok := names[id]; ok {
+		return fmt.Sprintf("%s (%s)", id, name)
+	}
+	return fmt.Sprintf("%s (unknown)", id)
+}
+
+func containerEncryptCmd(cmd *cli.Cmd) {
+	cmd.Spec = "[IN] [OPTIONS]"
+
+	in := cmd.StringArg("IN", "-", "file to encrypt, or - for stdin")
+	out := cmd.StringOpt("out", "-", "container output file, or - for stdout")
+	force := cmd.BoolOpt("force", false, "overwrite an existing output file")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("encrypting container")
+
+		app.Authorize(PermManageOrg, nil)
+
+		content, err := readInput(*in)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		container, err := orgEntity(app).EncryptThenSignString(string(content), nil)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		app.Audit("container encrypt", *in, "", "")
+
+		if err := WriteExport([]byte(container.Dump()), *out, 0600, -1, -1, *force); err != nil {
+			app.Fatal(err)
+		}
+	}
+}
+
+func containerDecryptCmd(cmd *cli.Cmd) {
+	cmd.Spec = "[IN] [OPTIONS]"
+
+	in := cmd.StringArg("IN", "-", "container file, or - for stdin")
+	out := cmd.StringOpt("out", "-", "decrypted output file, or - for stdout")
+	force := cmd.BoolOpt("force", false, "overwrite an existing output file")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("decrypting container")
+
+		app.Authorize(PermReadPrivate, nil)
+
+		container, err := readContainer(*in)
+		if err != nil {
+			app.Fail(err)
+		}
+
+		content, err := orgEntity(app).VerifyThenDecrypt(container)
+		if err != nil {
+			app.Fail(fmt.Errorf("could not decrypt '%s': %s", *in, err))
+		}
+
+		app.Audit("container decrypt", *in, "", "")
+
+		if err := WriteExport([]byte(content), *out, 0600, -1, -1, *force); err != nil {
+			app.Fatal(err)
+		}
+	}
+}
+
+func containerVerifyCmd(cmd *cli.Cmd) {
+	cmd.Spec = "[IN] [OPTIONS]"
+
+	in := cmd.StringArg("IN", "-", "container file, or - for stdin")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("verifying container")
+
+		container, err := readContainer(*in)
+		if err != nil {
+			app.Fail(err)
+		}
+
+		names := entityNames(app)
+
+		doc := NewOutputDoc().Add("signer", "Signer", describeEntity(names, container.Data.Options.Source))
+		if err := orgEntity(app).Verify(container); err != nil {
+			app.RenderItem(doc.Add("valid", "Valid", false).Add("error", "Error", err.Error()))
+			app.ExitWith(1)
+		}
+
+		app.RenderItem(doc.Add("valid", "Valid", true))
+	}
+}
+
+func containerInspectCmd(cmd *cli.Cmd) {
+	cmd.Spec = "[IN] [OPTIONS]"
+
+	in := cmd.StringArg("IN", "-", "container file, or - for stdin")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("inspecting container")
+
+		container, err := readContainer(*in)
+		if err != nil {
+			app.Fail(err)
+		}
+
+		names := entityNames(app)
+
+		options := container.Data.Options
+
+		var recipients []string
+		for id := range options.EncryptionKeys {
+			recipients = append(recipients, describeEntity(names, id))
+		}
+		sort.Strings(recipients)
+
+		app.RenderItem(NewOutputDoc().
+			Add("scope", "Scope", container.Data.Scope).
+			Add("version", "Version", container.Data.Version).
+			Add("type", "Type", container.Data.Type).
+			Add("signer", "Signer", describeEntity(names, options.Source)).
+			Add("signature_mode", "Signature mode", options.SignatureMode).
+			Add("encryption_mode", "Encryption mode", options.EncryptionMode).
+			Add("recipients", "Recipients", recipients).
+			Add("body_size", "Body size", len(container.Data.Body)))
+	}
+}
@@ -2,9 +2,12 @@
 package main
 
 import (
+	cx509 "crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
 	"github.com/jawher/mow.cli"
 	"github.com/pki-io/controller"
+	"github.com/pki-io/core/x509"
 )
 
 func csrCmd(cmd *cli.Cmd) {
@@ -16,6 +19,28 @@ func csrCmd(cmd *cli.Cmd) {
 	cmd.Command("delete", "Delete a CSR", csrDeleteCmd)
 }
 
+func csrOutput(csr *x509.CSR, private bool) *OutputDoc {
+	doc := NewOutputDoc().
+		Add("id", "Id", csr.Id()).
+		Add("name", "Name", csr.Name()).
+		Add("tags", "Tags", csr.Data.Body.Tags).
+		Add("key_type", "Key type", csr.Data.Body.KeyType)
+
+	if c, err := parseCSR(csr.Data.Body.CSR); err != nil {
+		logger.Warnf("could not parse CSR '%s': %s", csr.Name(), err)
+	} else {
+		doc.Add("extensions", "Requested extensions", subjectAltNames(c.DNSNames, c.IPAddresses, c.URIs, c.EmailAddresses))
+	}
+
+	doc.AddBlock("csr", "CSR", csr.Data.Body.CSR)
+
+	if private {
+		doc.AddBlock("private_key", "Private key", csr.Data.Body.PrivateKey)
+	}
+
+	return doc
+}
+
 func csrNewCmd(cmd *cli.Cmd) {
 	cmd.Spec = "NAME [OPTIONS]"
 
@@ -26,25 +51,47 @@ func csrNewCmd(cmd *cli.Cmd) {
 	params.StandaloneFile = cmd.StringOpt("standalone", "", "CSR isn't managed by the org but is exported as a tar.gz")
 	params.CsrFile = cmd.StringOpt("csr", "", "CSR PEM file")
 	params.KeyFile = cmd.StringOpt("key", "", "key PEM file")
-	params.KeyType = cmd.StringOpt("key-type", "ec", "Key type (ec or rsa)")
-	params.DnLocality = cmd.StringOpt("dn-l", "", "Locality for DN")
-	params.DnState = cmd.StringOpt("dn-st", "", "State/province for DN")
-	params.DnOrg = cmd.StringOpt("dn-o", "", "Organization for DN")
-	params.DnOrgUnit = cmd.StringOpt("dn-ou", "", "Organizational unit for DN")
-	params.DnCountry = cmd.StringOpt("dn-c", "", "Country for DN")
-	params.DnStreet = cmd.StringOpt("dn-street", "", "Street for DN")
-	params.DnPostal = cmd.StringOpt("dn-postal", "", "PostalCode for DN")
+	params.KeyType = cmd.StringOpt("key-type", profile.String(ProfileKeyType, "ec"), "Key type (ec or rsa)")
+	params.DnLocality = cmd.StringOpt("dn-l", profile.String(ProfileDnLocality, ""), "Locality for DN")
+	params.DnState = cmd.StringOpt("dn-st", profile.String(ProfileDnState, ""), "State/province for DN")
+	params.DnOrg = cmd.StringOpt("dn-o", profile.String(ProfileDnOrg, ""), "Organization for DN")
+	params.DnOrgUnit = cmd.StringOpt("dn-ou", profile.String(ProfileDnOrgUnit, ""), "Organizational unit for DN")
+	params.DnCountry = cmd.StringOpt("dn-c", profile.String(ProfileDnCountry, ""), "Country for DN")
+	params.DnStreet = cmd.StringOpt("dn-street", profile.String(ProfileDnStreet, ""), "Street for DN")
+	params.DnPostal = cmd.StringOpt("dn-postal", profile.String(ProfileDnPostal, ""), "PostalCode for DN")
+	extParams := NewExtensionParams(cmd)
+	exportParams := NewExportParams()
+	exportParams.Force = cmd.BoolOpt("force", false, "overwrite an existing export file")
 
 	cmd.Action = func() {
 		app := NewAdminApp()
 		logger.Info("creating new CSR")
 
+		app.Authorize(PermManageCert, tagsOf(entityTags(*params.Tags, *params.Name)))
+
+		ext := new(Extensions)
+		if err := extParams.Parse(ext); err != nil {
+			app.Fail(err)
+		}
+		if extParams.Given() && (*params.CsrFile != "" || *params.KeyFile != "") {
+			app.Fail(fmt.Errorf("extension options can't be used with --csr and --key"))
+		}
+
 		cont, err := controller.NewCSR(app.env)
 		if err != nil {
 			app.Fatal(err)
 		}
 
-		csr, err := cont.New(params)
+		app.Audit("csr new", *params.Name, "", "")
+
+		var csr *x509.CSR
+		if extParams.Given() {
+			// The controller can't request extensions, so the CSR is made
+			// here and imported
+			csr, err = newCSRWithExtensions(cont, params, ext)
+		} else {
+			csr, err = cont.New(params)
+		}
 		if err != nil {
 			app.Fatal(err)
 		}
@@ -54,31 +101,55 @@ func csrNewCmd(cmd *cli.Cmd) {
 		}
 
 		if *params.StandaloneFile == "" {
-			table := app.NewTable()
-
-			csrData := [][]string{
-				[]string{"Id", csr.Id()},
-				[]string{"Name", csr.Name()},
-			}
-			table.AppendBulk(csrData)
-
-			app.RenderTable(table)
-			fmt.Println("")
-			fmt.Printf("CSR:\n%s\n", csr.Data.Body.CSR)
+			app.RenderResult(csrOutput(csr, false), NewOutputDoc().
+				Add("id", "Id", csr.Id()).
+				Add("name", "Name", csr.Name()).
+				AddBlock("csr", "CSR", csr.Data.Body.CSR))
 		} else {
 			var files []ExportFile
 			csrFile := fmt.Sprintf("%s-csr.pem", csr.Data.Body.Name)
 			keyFile := fmt.Sprintf("%s-key.pem", csr.Data.Body.Name)
 
-			files = append(files, ExportFile{Name: csrFile, Mode: 0644, Content: []byte(csr.Data.Body.CSR)})
-			files = append(files, ExportFile{Name: keyFile, Mode: 0600, Content: []byte(csr.Data.Body.PrivateKey)})
+			files = append(files, NewExportFile(csrFile, ExportFileCSR, 0644, []byte(csr.Data.Body.CSR)))
+			files = append(files, NewExportFile(keyFile, ExportFileKey, 0600, []byte(csr.Data.Body.PrivateKey)))
 
 			logger.Debugf("exporting to '%s'", *params.StandaloneFile)
-			Export(files, *params.StandaloneFile)
+			if err := ExportFormat(files, *params.StandaloneFile, exportParams); err != nil {
+				app.Fatal(err)
+			}
 		}
 	}
 }
 
+// newCSRWithExtensions generates a key and a CSR requesting ext, and imports
+// them with the controller.
+func newCSRWithExtensions(cont *controller.CSRController, params *controller.CSRParams, ext *Extensions) (*x509.CSR, error) {
+	key, err := newPrivateKey(*params.KeyType)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := subjectName(*params.Name, *params.DnCountry, *params.DnState, *params.DnLocality, *params.DnOrg, *params.DnOrgUnit, *params.DnStreet, *params.DnPostal)
+	csrPEM, err := newCSR(subject, ext, key)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := privateKeyPEM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var csr *x509.CSR
+	err = withTempFiles([]string{csrPEM, keyPEM}, func(paths []string) error {
+		params.CsrFile = &paths[0]
+		params.KeyFile = &paths[1]
+		csr, err = cont.New(params)
+		return err
+	})
+	return csr, err
+}
+
 func csrListCmd(cmd *cli.Cmd) {
 	params := controller.NewCSRParams()
 
@@ -96,14 +167,12 @@ func csrListCmd(cmd *cli.Cmd) {
 			app.Fatal(err)
 		}
 
-		table := app.NewTable()
-		table.SetHeader([]string{"Name", "Id"})
-
+		var docs []*OutputDoc
 		for _, csr := range csrs {
-			table.Append([]string{csr.Name(), csr.Id()})
+			docs = append(docs, csrOutput(csr, false))
 		}
 
-		app.RenderTable(table)
+		app.RenderList(docs, "Name", "Id")
 	}
 }
 
@@ -115,11 +184,23 @@ func csrShowCmd(cmd *cli.Cmd) {
 
 	params.Export = cmd.StringOpt("export", "", "tar.gz export to file")
 	params.Private = cmd.BoolOpt("private", false, "show/export private data")
+	exportParams := NewExportParams()
+	exportParams.Force = cmd.BoolOpt("force", false, "overwrite an existing export file")
+	exportParams.Dir = cmd.StringOpt("export-dir", "", "export files into a directory")
+	exportParams.Names = cmd.StringsOpt("export-name", nil, "file name template for --export-dir as TYPE=TEMPLATE, e.g. csr={name}.csr (repeatable)")
 
 	cmd.Action = func() {
 		app := NewAdminApp()
 		logger.Info("showing CSR")
 
+		if *params.Private {
+			app.Authorize(PermReadPrivate, csrTags(app, *params.Name))
+		}
+
+		if err := exportParams.Check(*params.Export); err != nil {
+			app.Fail(err)
+		}
+
 		cont, err := controller.NewCSR(app.env)
 		if err != nil {
 			app.Fatal(err)
@@ -134,36 +215,27 @@ func csrShowCmd(cmd *cli.Cmd) {
 			return
 		}
 
-		if *params.Export == "" {
-			table := app.NewTable()
-
-			csrData := [][]string{
-				[]string{"Id", csr.Id()},
-				[]string{"Name", csr.Name()},
-				[]string{"Key type", csr.Data.Body.KeyType},
-			}
-
-			table.AppendBulk(csrData)
-
-			app.RenderTable(table)
+		if *params.Private {
+			app.Audit("csr show --private", *params.Name, csr.Id(), "")
+		}
 
-			fmt.Printf("CSR:\n%s\n", csr.Data.Body.CSR)
-			if *params.Private {
-				fmt.Printf("Private key:\n%s\n", csr.Data.Body.PrivateKey)
-			}
+		if *params.Export == "" && *exportParams.Dir == "" {
+			app.RenderItem(csrOutput(csr, *params.Private))
 		} else {
 			var files []ExportFile
 			csrFile := fmt.Sprintf("%s-csr.pem", csr.Data.Body.Name)
 			keyFile := fmt.Sprintf("%s-key.pem", csr.Data.Body.Name)
 
-			files = append(files, ExportFile{Name: csrFile, Mode: 0644, Content: []byte(csr.Data.Body.CSR)})
+			files = append(files, NewExportFile(csrFile, ExportFileCSR, 0644, []byte(csr.Data.Body.CSR)))
 
 			if *params.Private {
-				files = append(files, ExportFile{Name: keyFile, Mode: 0600, Content: []byte(csr.Data.Body.PrivateKey)})
+				files = append(files, NewExportFile(keyFile, ExportFileKey, 0600, []byte(csr.Data.Body.PrivateKey)))
 			}
 
-			logger.Infof("Exporting to '%s'", *params.Export)
-			Export(files, *params.Export)
+			logger.Infof("Exporting CSR '%s'", csr.Data.Body.Name)
+			if err := Export(files, csr.Data.Body.Name, *params.Export, exportParams); err != nil {
+				app.Fatal(err)
+			}
 		}
 	}
 }
@@ -175,6 +247,8 @@ func csrSignCmd(cmd *cli.Cmd) {
 	params.Name = cmd.StringArg("NAME", "", "name of CSR")
 
 	params.KeepSubject = cmd.BoolOpt("keep-subject", false, "keep subject from CSR")
+	keepExtensions := cmd.BoolOpt("keep-extensions", false, "keep requested extensions from CSR, unless overridden by the options below")
+	extParams := NewExtensionParams(cmd)
 	params.Ca = cmd.StringOpt("ca", "", "name of signing CA")
 	params.Tags = cmd.StringOpt("tags", "", "comma separated list of tags")
 
@@ -182,31 +256,108 @@ func csrSignCmd(cmd *cli.Cmd) {
 		app := NewAdminApp()
 		logger.Info("signing CSR")
 
+		app.Authorize(PermIssue, caTags(app, *params.Ca))
+
+		if err := extParams.Parse(new(Extensions)); err != nil {
+			app.Fail(err)
+		}
+
 		cont, err := controller.NewCSR(app.env)
 		if err != nil {
 			app.Fatal(err)
 		}
 
-		cert, err := cont.Sign(params)
-		if err != nil {
+		app.Audit("csr sign", *params.Name, "", *params.Ca)
+
+		var cert *x509.Certificate
+		if *keepExtensions || extParams.Given() {
+			// The controller drops the CSR's extensions and can't add
+			// any, so the certificate is signed here and imported
+			cert = signCSRWithExtensions(app, cont, params, *keepExtensions, extParams)
+		} else if cert, err = cont.Sign(params); err != nil {
 			app.Fatal(err)
 		}
 
 		if cert != nil {
-			table := app.NewTable()
+			app.RenderResult(certOutput(cert, nil, false), NewOutputDoc().
+				Add("id", "Id", cert.Id()).
+				Add("name", "Name", cert.Name()).
+				AddBlock("certificate", "Certificate", cert.Data.Body.Certificate))
+		}
+	}
+}
 
-			certData := [][]string{
-				[]string{"Id", cert.Id()},
-				[]string{"Name", cert.Name()},
-				[]string{"Key type", cert.Data.Body.KeyType},
-			}
+// signCSRWithExtensions signs a CSR with the extensions it requested if keep
+// is true, replaced by any given on the command line.
+func signCSRWithExtensions(app *AdminApp, cont *controller.CSRController, params *controller.CSRParams, keep bool, extParams *ExtensionParams) *x509.Certificate {
+	private := true
+	params.Private = &private
 
-			table.AppendBulk(certData)
+	csr, err := cont.Show(params)
+	if err != nil {
+		app.Fatal(err)
+	}
+	if csr == nil {
+		app.Fail(fmt.Errorf("CSR '%s' not found", *params.Name))
+	}
 
-			app.RenderTable(table)
-			fmt.Printf("Certificate:\n%s\n", cert.Data.Body.Certificate)
+	request, err := parseCSR(csr.Data.Body.CSR)
+	if err != nil {
+		app.Fatal(err)
+	}
+	if err := request.CheckSignature(); err != nil {
+		app.Fail(fmt.Errorf("CSR '%s' has a bad signature: %s", *params.Name, err))
+	}
+
+	ext := new(Extensions)
+	if keep {
+		if ext, err = RequestedExtensions(request); err != nil {
+			app.Fail(err)
 		}
 	}
+	if err := extParams.Parse(ext); err != nil {
+		app.Fail(err)
+	}
+
+	ca := showSigningCA(app, *params.Ca)
+	if ca == nil {
+		app.Fail(fmt.Errorf("--ca is required to sign a CSR"))
+	}
+
+	// The CA's DN scope applies to a kept subject as well
+	subject := pkix.Name{CommonName: *params.Name}
+	if *params.KeepSubject {
+		subject = request.Subject
+	}
+	template := &cx509.Certificate{Subject: scopeSubject(subject, ca)}
+	ext.Apply(template)
+
+	caCert, err := parseCertificate(ca.Data.Body.Certificate)
+	if err != nil {
+		app.Fatal(err)
+	}
+	caKey, err := parsePrivateKey(ca.Data.Body.PrivateKey)
+	if err != nil {
+		app.Fail(fmt.Errorf("could not load the private key of CA '%s': %s", *params.Ca, err))
+	}
+	if template.NotBefore, template.NotAfter, err = validity(ca.Data.Body.CertExpiry, caCert); err != nil {
+		app.Fail(err)
+	}
+
+	certPEM, err := signCertificate(template, request.PublicKey, caCert, caKey)
+	if err != nil {
+		app.Fatal(err)
+	}
+
+	certCont, err := controller.NewCertificate(app.env)
+	if err != nil {
+		app.Fatal(err)
+	}
+
+	certParams := controller.NewCertificateParams()
+	certParams.Name = params.Name
+	certParams.Tags = params.Tags
+	return importCert(app, certCont, certParams, certPEM, csr.Data.Body.PrivateKey)
 }
 
 func csrUpdateCmd(cmd *cli.Cmd) {
@@ -223,11 +374,15 @@ func csrUpdateCmd(cmd *cli.Cmd) {
 		app := NewAdminApp()
 		logger.Info("updating CSR")
 
+		app.Authorize(PermManageCert, csrTags(app, *params.Name))
+
 		cont, err := controller.NewCSR(app.env)
 		if err != nil {
 			app.Fatal(err)
 		}
 
+		app.Audit("csr update", *params.Name, "", "")
+
 		if err := cont.Update(params); err != nil {
 			app.Fatal(err)
 		}
@@ -246,11 +401,15 @@ func csrDeleteCmd(cmd *cli.Cmd) {
 		app := NewAdminApp()
 		logger.Info("deleting CSR")
 
+		app.Authorize(PermManageCert, csrTags(app, *params.Name))
+
 		cont, err := controller.NewCSR(app.env)
 		if err != nil {
 			app.Fatal(err)
 		}
 
+		app.Audit("csr delete", *params.Name, "", *params.ConfirmDelete)
+
 		if err := cont.Delete(params); err != nil {
 			app.Fatal(err)
 		}
@@ -4,6 +4,8 @@ package main
 import (
 	"github.com/jawher/mow.cli"
 	"github.com/pki-io/controller"
+	"os"
+	"path/filepath"
 )
 
 func initCmd(cmd *cli.Cmd) {
@@ -22,8 +24,31 @@ func initCmd(cmd *cli.Cmd) {
 			app.Fatal(err)
 		}
 
+		local, err := localDir()
+		if err != nil {
+			app.Fatal(err)
+		}
+
 		if err := cont.Init(params); err != nil {
 			app.Fatal(err)
 		}
+
+		// The org is made in a directory named after it, which is where
+		// later commands run from, so its store is there too.
+		if err := os.Setenv("PKIIO_LOCAL", filepath.Join(local, *params.Org)); err != nil {
+			app.Fatal(err)
+		}
+		app = NewAdminApp()
+
+		if err := setCurrentAdmin(app, *params.Admin); err != nil {
+			app.Fatal(err)
+		}
+		if err := setRole(app, *params.Admin, &Role{Name: RoleOwner}); err != nil {
+			app.Fatal(err)
+		}
+
+		// The audit log is in the org, so this is the one entry that can't
+		// be written before the change
+		app.Audit("org init", *params.Org, "", "")
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/jawher/mow.cli"
 	"github.com/pki-io/controller"
+	"os"
 )
 
 func nodeCmd(cmd *cli.Cmd) {
@@ -16,23 +17,80 @@ func nodeCmd(cmd *cli.Cmd) {
 }
 
 func nodeNewCmd(cmd *cli.Cmd) {
-	cmd.Spec = "NAME [OPTIONS] [--host --agent-file -- [SSH_OPTIONS]]"
+	cmd.Spec = "NAME [OPTIONS] [-- SSH_OPTIONS...]"
 
 	params := controller.NewNodeParams()
 	params.Name = cmd.StringArg("NAME", "", "name of node")
 
 	params.PairingId = cmd.StringOpt("pairing-id", "", "pairing id")
-	params.PairingKey = cmd.StringOpt("pairing-key", "", "pairing key")
+	params.PairingKey = cmd.StringOpt("pairing-key", "", "pairing key (defaults to $PKIIO_PAIRING_KEY)")
 
-	params.Host = cmd.StringOpt("host", "", "node hostname or ip address")
-	params.AgentFile = cmd.StringOpt("agent-file", "", "path to agent package")
+	params.Host = cmd.StringOpt("host", "", "install the agent on a remote [user@]host over SSH, which must already share the org's local directory, e.g. over NFS")
+	params.AgentFile = cmd.StringOpt("agent-file", "", "path to agent binary (defaults to this binary)")
 	params.InstallFile = cmd.StringOpt("install-file", "./agent-installer.sh", "path to agent installer script")
-	params.SSHOptions = cmd.StringArg("SSH_OPTIONS", "", "arguments to pass to ssh NOT WORKING YET")
+	prefix := cmd.StringOpt("prefix", "/usr/local", "install prefix for the agent on the remote host")
+	dataDir := cmd.StringOpt("data-dir", "/var/lib/pki.io", "agent data directory on the remote host")
+	remoteLocal := cmd.StringOpt("remote-local", "", "org's local directory as shared with the remote host, which isn't copied there (defaults to this one)")
+	remoteHome := cmd.StringOpt("remote-home", "", "home directory on the remote host (defaults to $PKIIO_HOME)")
+	interval := cmd.StringOpt("interval", "5m", "time between agent runs")
+	sudo := cmd.BoolOpt("sudo", false, "run the remote installer with sudo")
+	noSystemd := cmd.BoolOpt("no-systemd", false, "don't install a systemd unit on the remote host")
+	sshOptions := cmd.StringsArg("SSH_OPTIONS", nil, "arguments to pass to ssh")
 
 	cmd.Action = func() {
 		app := NewAdminApp()
 		logger.Info("creating new node")
 
+		// The key can be kept off the command line, where other users on the
+		// host could see it
+		if *params.PairingKey == "" {
+			*params.PairingKey = os.Getenv("PKIIO_PAIRING_KEY")
+		}
+
+		if *params.Host != "" {
+			if *params.PairingId == "" || *params.PairingKey == "" {
+				app.Fail(fmt.Errorf("--host needs --pairing-id and --pairing-key or $PKIIO_PAIRING_KEY"))
+			}
+
+			// The node has to use the org's directories, which are shared
+			// with the remote host, so default to the ones used here
+			if *remoteLocal == "" {
+				local, err := localDir()
+				if err != nil {
+					app.Fatal(err)
+				}
+				*remoteLocal = local
+			}
+			if *remoteHome == "" {
+				*remoteHome = os.Getenv("PKIIO_HOME")
+			}
+
+			bootstrap := NewNodeBootstrap(*params.Host, *sshOptions)
+			bootstrap.Prefix = *prefix
+			bootstrap.DataDir = *dataDir
+			bootstrap.LocalDir = *remoteLocal
+			bootstrap.HomeDir = *remoteHome
+			bootstrap.Interval = *interval
+			bootstrap.Sudo = *sudo
+			bootstrap.NoSystemd = *noSystemd
+			bootstrap.InstallFile = *params.InstallFile
+			bootstrap.AgentFile = *params.AgentFile
+			if bootstrap.AgentFile == "" {
+				agent, err := os.Executable()
+				if err != nil {
+					app.Fatal(err)
+				}
+				bootstrap.AgentFile = agent
+			}
+
+			steps, err := bootstrap.Install(*params.Name, *params.PairingId, *params.PairingKey)
+			app.RenderList(steps, "Step", "Status", "Detail")
+			if err != nil {
+				app.Fatal(err)
+			}
+			return
+		}
+
 		cont, err := controller.NewNode(app.env)
 		if err != nil {
 			app.Fatal(err)
@@ -44,12 +102,9 @@ func nodeNewCmd(cmd *cli.Cmd) {
 		}
 
 		if node != nil {
-			table := app.NewTable()
-			table.SetHeader([]string{"Name", "Id"})
-
-			table.Append([]string{node.Name(), node.Id()})
-
-			app.RenderTable(table)
+			app.RenderResult(entityOutput(node.Id(), node.Name(), node.Data.Body.KeyType, node.Data.Body.PublicSigningKey, node.Data.Body.PublicEncryptionKey), NewOutputDoc().
+				Add("id", "Id", node.Id()).
+				Add("name", "Name", node.Name()))
 		}
 	}
 }
@@ -60,16 +115,32 @@ func nodeRunCmd(cmd *cli.Cmd) {
 	params := controller.NewNodeParams()
 	params.Name = cmd.StringArg("NAME", "", "name of node")
 
+	daemon := cmd.BoolOpt("daemon", false, "keep running tasks every interval")
+	interval := cmd.StringOpt("interval", "5m", "time between runs in daemon mode, e.g. 5m or 1h")
+	maxBackoff := cmd.StringOpt("max-backoff", "1h", "longest wait between retries after failed runs in daemon mode")
+	statusFile := cmd.StringOpt("status-file", "node-status.json", "daemon status file")
+	deployFile := cmd.StringOpt("deploy-config", "", "TOML config for deploying the node's certificates to files")
+
 	cmd.Action = func() {
 		app := NewAdminApp()
 		logger.Info("running node tasks")
 
-		cont, err := controller.NewNode(app.env)
-		if err != nil {
-			app.Fatal(err)
+		if *daemon {
+			every, err := parseWindow(*interval)
+			if err != nil || every <= 0 {
+				app.Fail(fmt.Errorf("invalid interval: %s", *interval))
+			}
+
+			backoff, err := parseWindow(*maxBackoff)
+			if err != nil || backoff <= 0 {
+				app.Fail(fmt.Errorf("invalid max backoff: %s", *maxBackoff))
+			}
+
+			NewNodeDaemon(app, params, every, backoff, *statusFile, *deployFile).Run()
+			return
 		}
 
-		if err := cont.Run(params); err != nil {
+		if _, err := runNode(app, params, *deployFile); err != nil {
 			app.Fatal(err)
 		}
 	}
@@ -88,11 +159,22 @@ func nodeCertCmd(cmd *cli.Cmd) {
 		app := NewAdminApp()
 		logger.Info("creating node certificate")
 
+		app.Authorize(PermManageNode, nil)
+		if *params.Private {
+			app.Authorize(PermReadPrivate, nil)
+		}
+
 		cont, err := controller.NewNode(app.env)
 		if err != nil {
 			app.Fatal(err)
 		}
 
+		action := "node cert"
+		if *params.Private {
+			action = "node cert --private"
+		}
+		app.Audit(action, *params.Name, "", "")
+
 		if err := cont.Cert(params); err != nil {
 			app.Fatal(err)
 		}
@@ -119,14 +201,12 @@ func nodeListCmd(cmd *cli.Cmd) {
 			app.Fatal(err)
 		}
 
-		table := app.NewTable()
-		table.SetHeader([]string{"Name", "Id"})
-
+		var docs []*OutputDoc
 		for _, node := range nodes {
-			table.Append([]string{node.Name(), node.Id()})
+			docs = append(docs, entityOutput(node.Id(), node.Name(), node.Data.Body.KeyType, node.Data.Body.PublicSigningKey, node.Data.Body.PublicEncryptionKey))
 		}
 
-		app.RenderTable(table)
+		app.RenderList(docs, "Name", "ID")
 		app.Exit()
 	}
 }
@@ -152,19 +232,7 @@ func nodeShowCmd(cmd *cli.Cmd) {
 		}
 
 		if node != nil {
-			table := app.NewTable()
-
-			nodeData := [][]string{
-				[]string{"ID", node.Id()},
-				[]string{"Name", node.Name()},
-				[]string{"Key type", node.Data.Body.KeyType},
-			}
-			table.AppendBulk(nodeData)
-
-			app.RenderTable(table)
-			fmt.Println("")
-			fmt.Printf("Public signing key:\n%s\n", node.Data.Body.PublicSigningKey)
-			fmt.Printf("Public encryption key:\n%s\n", node.Data.Body.PublicEncryptionKey)
+			app.RenderItem(entityOutput(node.Id(), node.Name(), node.Data.Body.KeyType, node.Data.Body.PublicSigningKey, node.Data.Body.PublicEncryptionKey))
 		}
 	}
 }
@@ -180,11 +248,15 @@ func nodeDeleteCmd(cmd *cli.Cmd) {
 	cmd.Action = func() {
 		app := NewAdminApp()
 		logger.Info("deleting node")
+
+		app.Authorize(PermManageNode, nil)
 		cont, err := controller.NewNode(app.env)
 		if err != nil {
 			app.Fatal(err)
 		}
 
+		app.Audit("node delete", *params.Name, "", *params.ConfirmDelete)
+
 		if err := cont.Delete(params); err != nil {
 			app.Fatal(err)
 		}
@@ -5,12 +5,17 @@ import (
 	"fmt"
 	"github.com/jawher/mow.cli"
 	"github.com/pki-io/controller"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 )
 
 func orgCmd(cmd *cli.Cmd) {
 	cmd.Command("list", "List organisations", orgListCmd)
 	cmd.Command("show", "Show an organisation", orgShowCmd)
 	cmd.Command("run", "Run organisation tasks", orgRunCmd)
+	cmd.Command("backup", "Back up an organisation", orgBackupCmd)
+	cmd.Command("restore", "Restore an organisation from a backup", orgRestoreCmd)
 	cmd.Command("delete", "Delete an organisation", orgDeleteCmd)
 }
 
@@ -33,14 +38,12 @@ func orgListCmd(cmd *cli.Cmd) {
 			app.Fatal(err)
 		}
 
-		table := app.NewTable()
-		table.SetHeader([]string{"Name", "Id"})
-
+		var docs []*OutputDoc
 		for _, org := range orgs {
-			table.Append([]string{org.Name(), org.Id()})
+			docs = append(docs, entityOutput(org.Id(), org.Name(), org.Data.Body.KeyType, org.Data.Body.PublicSigningKey, org.Data.Body.PublicEncryptionKey))
 		}
 
-		app.RenderTable(table)
+		app.RenderList(docs, "Name", "ID")
 	}
 }
 
@@ -65,19 +68,7 @@ func orgShowCmd(cmd *cli.Cmd) {
 		}
 
 		if org != nil {
-			table := app.NewTable()
-
-			orgData := [][]string{
-				[]string{"ID", org.Id()},
-				[]string{"Name", org.Name()},
-				[]string{"Key type", org.Data.Body.KeyType},
-			}
-			table.AppendBulk(orgData)
-
-			app.RenderTable(table)
-			fmt.Println("")
-			fmt.Printf("Public signing key:\n%s\n", org.Data.Body.PublicSigningKey)
-			fmt.Printf("Public encryption key:\n%s\n", org.Data.Body.PublicEncryptionKey)
+			app.RenderItem(entityOutput(org.Id(), org.Name(), org.Data.Body.KeyType, org.Data.Body.PublicSigningKey, org.Data.Body.PublicEncryptionKey))
 		}
 	}
 }
@@ -91,11 +82,25 @@ func orgRunCmd(cmd *cli.Cmd) {
 		app := NewAdminApp()
 		logger.Info("running organisation tasks")
 
+		app.Authorize(PermManageNode, nil)
+
+		// Nodes register when the org runs, so expired pairing keys have to
+		// be gone by then
+		pairingCont, err := controller.NewPairingKey(app.env)
+		if err != nil {
+			app.Fatal(err)
+		}
+		if err := expirePairingKeys(app, pairingCont); err != nil {
+			app.Fatal(err)
+		}
+
 		cont, err := controller.NewOrg(app.env)
 		if err != nil {
 			app.Fatal(err)
 		}
 
+		app.Audit("org run", "", "", "")
+
 		if err := cont.Run(params); err != nil {
 			app.Fatal(err)
 		}
@@ -108,6 +113,7 @@ func orgDeleteCmd(cmd *cli.Cmd) {
 	params := controller.NewOrgParams()
 	params.Org = cmd.StringArg("ORG", "", "name of organisation")
 	params.ConfirmDelete = cmd.StringOpt("confirm-delete", "", "reason for deleting organisation")
+	approvalId := cmd.StringOpt("approval", "", "id of an approved request for this deletion")
 
 	cmd.Action = func() {
 		app := NewAdminApp()
@@ -118,8 +124,146 @@ func orgDeleteCmd(cmd *cli.Cmd) {
 			app.Fatal(err)
 		}
 
+		action := fmt.Sprintf(ActionOrgDelete, *params.Org)
+		app.Authorize(PermManageOrg, nil)
+		app.Approve(action, *approvalId)
+
+		// The audit log is deleted with the org, so the entry is written
+		// first for anyone holding a backup or a copy of the log
+		app.Audit("org delete", *params.Org, "", *params.ConfirmDelete)
+
+		// The approval goes with the org's store, so there is nothing left
+		// to use it up in
 		if err := cont.Delete(params); err != nil {
 			app.Fatal(err)
 		}
 	}
 }
+
+func orgBackupCmd(cmd *cli.Cmd) {
+	cmd.Spec = "(--out | --verify) [OPTIONS]"
+
+	out := cmd.StringOpt("out", "", "write an encrypted backup to file")
+	verify := cmd.StringOpt("verify", "", "check a backup file without restoring it")
+	force := cmd.BoolOpt("force", false, "overwrite an existing backup file")
+	approvalId := cmd.StringOpt("approval", "", "id of an approved request for this backup")
+	passphrase := new(Passphrase)
+	passphrase.Env = cmd.StringOpt("passphrase-env", "", "environment variable holding the backup passphrase")
+	passphrase.File = cmd.StringOpt("passphrase-file", "", "file holding the backup passphrase")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+
+		if *verify != "" {
+			logger.Info("verifying organisation backup")
+
+			backup, err := ioutil.ReadFile(*verify)
+			if err != nil {
+				app.Fatal(err)
+			}
+
+			pass, err := passphrase.Read()
+			if err != nil {
+				app.Fatal(err)
+			}
+
+			manifest, _, err := OpenBackup(backup, pass)
+			if err != nil {
+				app.Fail(err)
+			}
+			app.RenderItem(manifest.Output())
+			return
+		}
+
+		logger.Info("backing up organisation")
+
+		app.Authorize(PermManageOrg, nil)
+
+		localDir, err := localDir()
+		if err != nil {
+			app.Fatal(err)
+		}
+		homeDir, err := homeDir()
+		if err != nil {
+			app.Fatal(err)
+		}
+		org := filepath.Base(localDir)
+
+		pass, err := passphrase.Read()
+		if err != nil {
+			app.Fail(err)
+		}
+		if _, err := os.Stat(*out); err == nil && !*force {
+			app.Fail(fmt.Errorf("'%s' already exists, use --force to overwrite it", *out))
+		}
+
+		// A backup holds every CA's private key
+		action := fmt.Sprintf(ActionOrgBackup, org)
+		app.Approve(action, *approvalId)
+
+		app.Audit("org backup", org, "", "")
+
+		backup, manifest, err := Backup(org, localDir, homeDir, pass)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		logger.Debugf("writing backup to '%s'", *out)
+		if err := WriteExport(backup, *out, 0600, -1, -1, *force); err != nil {
+			app.Fatal(err)
+		}
+		app.CompleteApproval(action, *approvalId)
+		app.RenderItem(manifest.Output())
+	}
+}
+
+func orgRestoreCmd(cmd *cli.Cmd) {
+	cmd.Spec = "FILE --home [OPTIONS]"
+
+	file := cmd.StringArg("FILE", "", "backup file")
+	localDir := cmd.StringOpt("local", "", "new directory for the organisation (default ./ORG)")
+	homeDir := cmd.StringOpt("home", "", "new directory for the admin home, to be used as PKIIO_HOME")
+	passphrase := new(Passphrase)
+	passphrase.Env = cmd.StringOpt("passphrase-env", "", "environment variable holding the backup passphrase")
+	passphrase.File = cmd.StringOpt("passphrase-file", "", "file holding the backup passphrase")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("restoring organisation")
+
+		backup, err := ioutil.ReadFile(*file)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		pass, err := passphrase.Read()
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		manifest, contents, err := OpenBackup(backup, pass)
+		if err != nil {
+			app.Fail(err)
+		}
+
+		if *localDir == "" {
+			*localDir = manifest.Org
+		}
+
+		if err := Restore(manifest, contents, *localDir, homeDirIn(*homeDir)); err != nil {
+			app.Fail(err)
+		}
+
+		// There is no org to audit in until it has been restored, so the
+		// restore is recorded in the restored org's log
+		for env, dir := range map[string]string{"PKIIO_LOCAL": *localDir, "PKIIO_HOME": *homeDir} {
+			if err := os.Setenv(env, dir); err != nil {
+				app.Fatal(err)
+			}
+		}
+		NewAdminApp().Audit("org restore", manifest.Org, "", *file)
+
+		logger.Infof("restored '%s' into '%s', use it with PKIIO_HOME=%s", manifest.Org, *localDir, *homeDir)
+		app.RenderItem(manifest.Output())
+	}
+}
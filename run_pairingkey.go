@@ -2,8 +2,10 @@
 package main
 
 import (
+	"fmt"
 	"github.com/jawher/mow.cli"
 	"github.com/pki-io/controller"
+	"time"
 )
 
 func pairingKeyCmd(cmd *cli.Cmd) {
@@ -13,36 +15,122 @@ func pairingKeyCmd(cmd *cli.Cmd) {
 	cmd.Command("delete", "Delete a pairing key", pairingKeyDeleteCmd)
 }
 
+func pairingKeyOutput(id, key, tags string, private bool) *OutputDoc {
+	doc := NewOutputDoc().Add("id", "Id", id)
+	if private {
+		doc.Add("key", "Key", key)
+	}
+	doc.Add("tags", "Tags", splitTags(tags))
+
+	return doc
+}
+
+// pairingKeysDoc is the org store document holding when pairing keys were
+// created and expire, which the controller doesn't keep.
+const pairingKeysDoc = "pairing_keys"
+
+// PairingKeyTimes are unix seconds, with an expiry of 0 meaning never.
+type PairingKeyTimes struct {
+	Created int64 `json:"created"`
+	Expires int64 `json:"expires"`
+}
+
+// PairingKeys maps pairing key ids to their times.
+type PairingKeys map[string]*PairingKeyTimes
+
+// pairingKeyTimes adds the created and expires columns of a pairing key.
+// Keys created before times were kept have neither, and expired keys are
+// marked until org run deletes them.
+func pairingKeyTimes(doc *OutputDoc, times *PairingKeyTimes) *OutputDoc {
+	if times == nil {
+		return doc
+	}
+
+	expiresText := "never"
+	if times.Expires != 0 {
+		expiresText = formatTime(times.Expires)
+		if time.Now().Unix() >= times.Expires {
+			expiresText += " (expired)"
+		}
+	}
+
+	return doc.Add("created", "Created", formatTime(times.Created)).
+		Add("expires", "Expires", expiresText)
+}
+
+// expirePairingKeys deletes the pairing keys that have expired, so that nodes
+// can no longer register with them.
+func expirePairingKeys(app *AdminApp, cont *controller.PairingKeyController) error {
+	keys := make(PairingKeys)
+	return app.Store().Update(pairingKeysDoc, &keys, func() error {
+		now := time.Now().Unix()
+		for id, times := range keys {
+			if times.Expires == 0 || now < times.Expires {
+				continue
+			}
+
+			logger.Infof("deleting expired pairing key '%s'", id)
+			params := controller.NewPairingKeyParams()
+			keyId, reason := id, "expired"
+			params.Id = &keyId
+			params.ConfirmDelete = &reason
+			app.Audit("pairing-key delete", id, "", reason)
+			if err := cont.Delete(params); err != nil {
+				return fmt.Errorf("could not delete expired pairing key '%s': %s", id, err)
+			}
+			delete(keys, id)
+		}
+		return nil
+	})
+}
+
 func pairingKeyNewCmd(cmd *cli.Cmd) {
 	cmd.Spec = "[OPTIONS]"
 
 	params := controller.NewPairingKeyParams()
 	params.Tags = cmd.StringOpt("tags", "", "comma separated list of tags")
+	expiresIn := cmd.StringOpt("expires-in", "", "time until the key expires, e.g. 24h or 7d (default never), after which org run deletes it")
 
 	cmd.Action = func() {
 		app := NewAdminApp()
 		logger.Info("creating new pairing key")
 
+		app.Authorize(PermManageNode, nil)
+
+		now := time.Now()
+		times := &PairingKeyTimes{Created: now.Unix()}
+		if *expiresIn != "" {
+			window, err := parseWindow(*expiresIn)
+			if err != nil || window <= 0 {
+				app.Fail(fmt.Errorf("invalid expiry: %s", *expiresIn))
+			}
+			times.Expires = now.Add(window).Unix()
+		}
+
 		cont, err := controller.NewPairingKey(app.env)
 		if err != nil {
 			app.Fatal(err)
 		}
 
+		// The key has no id until it is made, so it is audited by its tags
+		app.Audit("pairing-key new", *params.Tags, "", "")
+
 		id, key, err := cont.New(params)
 		if err != nil {
 			app.Fatal(err)
 		}
 
-		if id != "" && key != "" {
-			table := app.NewTable()
-
-			keyData := [][]string{
-				[]string{"Id", id},
-				[]string{"Key", key},
-			}
-			table.AppendBulk(keyData)
+		keys := make(PairingKeys)
+		err = app.Store().Update(pairingKeysDoc, &keys, func() error {
+			keys[id] = times
+			return nil
+		})
+		if err != nil {
+			app.Fatal(err)
+		}
 
-			app.RenderTable(table)
+		if id != "" && key != "" {
+			app.RenderItem(NewOutputDoc().Add("id", "Id", id).Add("key", "Key", key))
 		}
 	}
 
@@ -65,14 +153,20 @@ func pairingKeyListCmd(cmd *cli.Cmd) {
 			app.Fatal(err)
 		}
 
-		table := app.NewTable()
-		table.SetHeader([]string{"Id", "Tags"})
+		times := make(PairingKeys)
+		if _, err := app.Store().Load(pairingKeysDoc, &times); err != nil {
+			app.Fatal(err)
+		}
 
+		var docs []*OutputDoc
 		for _, key := range keys {
-			table.Append([]string{key[0], key[1]})
+			if len(key) < 2 {
+				app.Fatal(fmt.Errorf("invalid pairing key list row: %v", key))
+			}
+			docs = append(docs, pairingKeyTimes(pairingKeyOutput(key[0], "", key[1], false), times[key[0]]))
 		}
 
-		app.RenderTable(table)
+		app.RenderList(docs, "Id", "Tags", "Created", "Expires")
 	}
 }
 
@@ -88,6 +182,12 @@ func pairingKeyShowCmd(cmd *cli.Cmd) {
 		app := NewAdminApp()
 		logger.Info("showing pairing key")
 
+		// Pairing keys let nodes join, so only those managing nodes may see
+		// them.
+		if *params.Private {
+			app.Authorize(PermManageNode, nil)
+		}
+
 		cont, err := controller.NewPairingKey(app.env)
 		if err != nil {
 			app.Fatal(err)
@@ -98,16 +198,12 @@ func pairingKeyShowCmd(cmd *cli.Cmd) {
 			app.Fatal(err)
 		}
 
-		if id != "" && key != "" && tags != "" {
-			table := app.NewTable()
-
-			table.Append([]string{"Id", id})
-			if *params.Private {
-				table.Append([]string{"Key", key})
-			}
-			table.Append([]string{"Tags", tags})
+		if *params.Private {
+			app.Audit("pairing-key show --private", *params.Id, "", "")
+		}
 
-			app.RenderTable(table)
+		if id != "" && key != "" && tags != "" {
+			app.RenderItem(pairingKeyOutput(id, key, tags, *params.Private))
 		}
 	}
 }
@@ -124,13 +220,26 @@ func pairingKeyDeleteCmd(cmd *cli.Cmd) {
 		app := NewAdminApp()
 		logger.Info("deleting pairing key")
 
+		app.Authorize(PermManageNode, nil)
+
 		cont, err := controller.NewPairingKey(app.env)
 		if err != nil {
 			app.Fatal(err)
 		}
 
+		app.Audit("pairing-key delete", *params.Id, "", *params.ConfirmDelete)
+
 		if err := cont.Delete(params); err != nil {
 			app.Fatal(err)
 		}
+
+		keys := make(PairingKeys)
+		err = app.Store().Update(pairingKeysDoc, &keys, func() error {
+			delete(keys, *params.Id)
+			return nil
+		})
+		if err != nil {
+			app.Fatal(err)
+		}
 	}
 }
@@ -0,0 +1,129 @@
+// ThreatSpec package main
+package main
+
+import (
+	"crypto/x509"
+	"github.com/jawher/mow.cli"
+	"github.com/pki-io/controller"
+	"time"
+)
+
+// Exit statuses of report expiry, so that scripts can tell expiring
+// certificates from ones that can't be checked at all.
+const (
+	ExitExpiring   = 1
+	ExitUnparsable = 3
+)
+
+func reportCmd(cmd *cli.Cmd) {
+	cmd.Command("expiry", "Report expired and expiring CAs and certificates, exiting with 1 if any are found, or 3 if any can't be parsed", reportExpiryCmd)
+}
+
+func expiryOutput(kind, id, name string, cert *x509.Certificate, filter *ExpiryFilter) *OutputDoc {
+	return NewOutputDoc().
+		Add("type", "Type", kind).
+		Add("id", "Id", id).
+		Add("name", "Name", name).
+		Add("serial", "Serial", cert.SerialNumber.String()).
+		Add("not_after", "Not after", cert.NotAfter.UTC().Format(time.RFC3339)).
+		Add("days_left", "Days left", filter.DaysLeft(cert.NotAfter)).
+		Add("status", "Status", filter.Status(cert.NotAfter))
+}
+
+// ThreatSpec TMv0.1 for reportExpiryCmd
+// Does expiry reporting for App:CLI
+
+func reportExpiryCmd(cmd *cli.Cmd) {
+	cmd.Spec = "[OPTIONS]"
+
+	within := cmd.StringOpt("within", "30d", "report CAs and certificates expiring within this period")
+	all := cmd.BoolOpt("all", false, "include CAs and certificates that aren't expiring")
+
+	cmd.Action = func() {
+		app := NewAdminApp()
+		logger.Info("reporting on expiry")
+
+		filter, err := NewExpiryFilter(*within, true)
+		if err != nil {
+			app.Fail(err)
+		}
+
+		caCont, err := controller.NewCA(app.env)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		cas, err := caCont.List(controller.NewCAParams())
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		certCont, err := controller.NewCertificate(app.env)
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		certs, err := certCont.List(controller.NewCertificateParams())
+		if err != nil {
+			app.Fatal(err)
+		}
+
+		var docs []*OutputDoc
+		found := 0
+		unparsable := 0
+
+		add := func(kind, id, name, certPEM string) {
+			cert, err := parseCertificate(certPEM)
+			if err != nil {
+				// A certificate that can't be checked is as much a problem
+				// as one that is expiring
+				logger.Warnf("could not parse %s '%s': %s", kind, name, err)
+				unparsable++
+				docs = append(docs, NewOutputDoc().
+					Add("type", "Type", kind).
+					Add("id", "Id", id).
+					Add("name", "Name", name).
+					Add("status", "Status", "unparsable"))
+				return
+			}
+
+			match := filter.Match(cert.NotAfter)
+			if match {
+				found++
+			}
+
+			if match || *all {
+				docs = append(docs, expiryOutput(kind, id, name, cert, filter))
+			}
+		}
+
+		for _, ca := range cas {
+			add("ca", ca.Id(), ca.Name(), ca.Data.Body.Certificate)
+		}
+
+		revocations := loadRevocations(app)
+		for _, cert := range certs {
+			if certRevocation(revocations, cert) != nil {
+				continue
+			}
+			add("cert", cert.Id(), cert.Name(), cert.Data.Body.Certificate)
+		}
+
+		app.RenderList(docs, "Type", "Name", "Id", "Not after", "Days left", "Status")
+
+		if found > 0 {
+			logger.Warnf("%d CAs and certificates expired or expiring within %s", found, *within)
+		}
+		if unparsable > 0 {
+			logger.Warnf("%d CAs and certificates could not be parsed", unparsable)
+		}
+		// Unparsable certificates take precedence, as they might be
+		// expiring too
+		if unparsable > 0 {
+			app.ExitWith(ExitUnparsable)
+		}
+		if found > 0 {
+			app.ExitWith(ExitExpiring)
+		}
+	}
+}
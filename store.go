@@ -0,0 +1,287 @@
+// ThreatSpec package main
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mitchellh/go-homedir"
+	"github.com/pki-io/controller"
+	"github.com/pki-io/core/document"
+	"github.com/pki-io/core/entity"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// storeDir is the directory in the org's local directory that holds the
+// store's documents.
+const storeDir = "cli"
+
+// How long Update waits for another admin's update of the same document.
+const (
+	storeLockWait  = 10 * time.Second
+	storeLockRetry = 100 * time.Millisecond
+)
+
+// OrgStore keeps the documents that the controllers have no place for, such
+// as revocations, next to the controllers' own in the org's local directory.
+// Like those, they are encrypted and signed with the org's keys, so only the
+// org's admins can read or change them.
+type OrgStore struct {
+	org *entity.Entity
+	dir string
+}
+
+// localDir returns the org's local directory, which is $PKIIO_LOCAL or else
+// the current directory.
+func localDir() (string, error) {
+	if dir := os.Getenv("PKIIO_LOCAL"); dir != "" {
+		return dir, nil
+	}
+	return os.Getwd()
+}
+
+// homeDir returns the admin's home directory, which is .pki.io in
+// $PKIIO_HOME or else in the user's home directory.
+func homeDir() (string, error) {
+	home := os.Getenv("PKIIO_HOME")
+	if home == "" {
+		dir, err := homedir.Dir()
+		if err != nil {
+			return "", err
+		}
+		home = dir
+	}
+	return homeDirIn(home), nil
+}
+
+// homeDirIn returns the admin's home directory for a PKIIO_HOME of home.
+func homeDirIn(home string) string {
+	return filepath.Join(home, ".pki.io")
+}
+
+// loadHomeDoc reads a JSON document, keyed by org id, from the CLI directory
+// of the home directory. Unlike the store's documents these belong to one
+// admin, so they aren't shared or encrypted. A missing document leaves v
+// alone.
+func loadHomeDoc(name string, v interface{}) error {
+	home, err := homeDir()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(home, storeDir, name+".json")
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("could not parse '%s': %s", path, err)
+	}
+	return nil
+}
+
+// loadPublicDoc reads a JSON document from the CLI directory of the org's
+// local directory. Unlike the store's documents it isn't encrypted or signed,
+// so it can be read by those who haven't joined the org yet and must hold
+// nothing secret. A missing document leaves v alone.
+func loadPublicDoc(name string, v interface{}) error {
+	local, err := localDir()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(local, storeDir, name+".json")
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("could not parse '%s': %s", path, err)
+	}
+	return nil
+}
+
+// savePublicDoc replaces a document in the CLI directory of the org's local
+// directory.
+func savePublicDoc(name string, v interface{}) error {
+	local, err := localDir()
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(local, storeDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return WriteExport(content, filepath.Join(dir, name+".json"), 0644, -1, -1, true)
+}
+
+// saveHomeDoc replaces a document in the CLI directory of the home
+// directory.
+func saveHomeDoc(name string, v interface{}) error {
+	home, err := homeDir()
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(home, storeDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return WriteExport(content, filepath.Join(dir, name+".json"), 0600, -1, -1, true)
+}
+
+func NewOrgStore(env *controller.Environment) (*OrgStore, error) {
+	cont, err := controller.NewOrg(env)
+	if err != nil {
+		return nil, err
+	}
+
+	private := true
+	params := controller.NewOrgParams()
+	params.Private = &private
+
+	org, err := cont.Show(params)
+	if err != nil {
+		return nil, err
+	}
+	if org == nil {
+		return nil, fmt.Errorf("could not load the org")
+	}
+
+	local, err := localDir()
+	if err != nil {
+		return nil, err
+	}
+
+	store := new(OrgStore)
+	store.org = org
+	store.dir = filepath.Join(local, storeDir)
+	return store, nil
+}
+
+// Store returns the org store, exiting if the org can't be loaded.
+func (app *AdminApp) Store() *OrgStore {
+	if app.store == nil {
+		store, err := NewOrgStore(app.env)
+		if err != nil {
+			app.Fatal(err)
+		}
+		app.store = store
+	}
+	return app.store
+}
+
+func (s *OrgStore) path(name string) string {
+	return filepath.Join(s.dir, name+".json")
+}
+
+// Load reads a document into v. It returns false, leaving v alone, if the
+// document doesn't exist yet.
+func (s *OrgStore) Load(name string, v interface{}) (bool, error) {
+	content, err := ioutil.ReadFile(s.path(name))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	container, err := document.NewContainer(string(content))
+	if err != nil {
+		return false, fmt.Errorf("could not load '%s': %s", name, err)
+	}
+
+	body, err := s.org.VerifyThenDecrypt(container)
+	if err != nil {
+		return false, fmt.Errorf("could not verify '%s': %s", name, err)
+	}
+
+	if err := json.Unmarshal([]byte(body), v); err != nil {
+		return false, fmt.Errorf("could not parse '%s': %s", name, err)
+	}
+	return true, nil
+}
+
+// Save replaces a document with v.
+func (s *OrgStore) Save(name string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	container, err := s.org.EncryptThenSignString(string(body), nil)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, "."+name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(container.Dump()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(name))
+}
+
+// Update loads a document into v, calls change and saves v again, while
+// holding a lock so that admins changing the same document at once don't
+// lose each other's changes. Nothing is saved if change fails.
+func (s *OrgStore) Update(name string, v interface{}, change func() error) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+
+	lock := s.path(name) + ".lock"
+	for start := time.Now(); ; {
+		f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+		if time.Since(start) > storeLockWait {
+			return fmt.Errorf("'%s' is locked, remove %s if no other admin is changing it", name, lock)
+		}
+		time.Sleep(storeLockRetry)
+	}
+	defer os.Remove(lock)
+
+	if _, err := s.Load(name, v); err != nil {
+		return err
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	return s.Save(name, v)
+}